@@ -45,6 +45,61 @@ INSERT INTO `accounts` (`id`, `email`, `password`, `screenname`, `uin`) VALUES
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `actions`
+--
+
+CREATE TABLE `actions` (
+  `id` int(11) NOT NULL,
+  `from_id` int(11) NOT NULL,
+  `to_id` int(11) NOT NULL,
+  `type` int(11) NOT NULL,
+  `zap` int(11) NOT NULL,
+  `date` bigint(30) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `bulletinqueue`
+--
+
+CREATE TABLE `bulletinqueue` (
+  `bulletin_id` int(11) NOT NULL,
+  `to_id` int(11) NOT NULL,
+  `notified` tinyint(1) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `bulletins`
+--
+
+CREATE TABLE `bulletins` (
+  `id` int(11) NOT NULL,
+  `from_id` int(11) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `body` text NOT NULL,
+  `date` bigint(30) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `contactinfo`
+--
+
+CREATE TABLE `contactinfo` (
+  `owner_id` int(11) NOT NULL,
+  `contact_id` int(11) NOT NULL,
+  `nickname` varchar(255) NOT NULL DEFAULT '',
+  `notes` text NOT NULL,
+  `nameselect` int(11) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `contacts`
 --
@@ -56,13 +111,48 @@ CREATE TABLE `contacts` (
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `directory`
+--
+
+CREATE TABLE `directory` (
+  `id` int(11) NOT NULL,
+  `fname` varchar(64) NOT NULL DEFAULT '',
+  `lname` varchar(64) NOT NULL DEFAULT '',
+  `city` varchar(64) NOT NULL DEFAULT '',
+  `state` varchar(64) NOT NULL DEFAULT '',
+  `country` varchar(64) NOT NULL DEFAULT '',
+  `listed` tinyint(1) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `mailbox`
+--
+
+CREATE TABLE `mailbox` (
+  `id` int(11) NOT NULL,
+  `to_id` int(11) NOT NULL,
+  `from_name` varchar(255) NOT NULL,
+  `from_addr` varchar(255) NOT NULL,
+  `subject` varchar(255) NOT NULL,
+  `body` text NOT NULL,
+  `date` bigint(20) NOT NULL,
+  `seen` tinyint(1) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `msn`
 --
 
 CREATE TABLE `msn` (
   `id` int(11) NOT NULL,
-  `clversion` int(11) NOT NULL
+  `clversion` int(11) NOT NULL,
+  `gtc` char(1) NOT NULL DEFAULT 'A',
+  `blp` char(2) NOT NULL DEFAULT 'AL'
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 --
@@ -75,6 +165,54 @@ INSERT INTO `msn` (`id`, `clversion`) VALUES
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `msngroupmembers`
+--
+
+CREATE TABLE `msngroupmembers` (
+  `owner_id` int(11) NOT NULL,
+  `contact_id` int(11) NOT NULL,
+  `groupid` int(11) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `msngroups`
+--
+
+CREATE TABLE `msngroups` (
+  `owner_id` int(11) NOT NULL,
+  `groupid` int(11) NOT NULL,
+  `name` varchar(255) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `msnlists`
+--
+
+CREATE TABLE `msnlists` (
+  `owner_id` int(11) NOT NULL,
+  `contact_id` int(11) NOT NULL,
+  `list` char(2) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `msnphones`
+--
+
+CREATE TABLE `msnphones` (
+  `id` int(11) NOT NULL,
+  `type` char(3) NOT NULL,
+  `number` varchar(255) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `myspace`
 --
@@ -106,6 +244,7 @@ INSERT INTO `myspace` (`id`, `avatartype`, `bandname`, `songname`, `age`, `gende
 --
 
 CREATE TABLE `offlinemsgs` (
+  `id` int(11) NOT NULL,
   `from_id` int(11) NOT NULL,
   `to_id` int(11) NOT NULL,
   `message` longtext NOT NULL,
@@ -114,6 +253,55 @@ CREATE TABLE `offlinemsgs` (
 
 -- --------------------------------------------------------
 
+--
+-- Table structure for table `offlinereceipts`
+--
+
+CREATE TABLE `offlinereceipts` (
+  `id` int(11) NOT NULL,
+  `from_id` int(11) NOT NULL,
+  `to_id` int(11) NOT NULL,
+  `date` bigint(30) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `presence`
+--
+
+CREATE TABLE `presence` (
+  `id` int(11) NOT NULL,
+  `status` int(11) NOT NULL DEFAULT 0,
+  `statustext` varchar(255) NOT NULL DEFAULT '',
+  `awaymsg` varchar(255) NOT NULL DEFAULT '',
+  `idlesince` bigint(20) NOT NULL DEFAULT 0,
+  `lastchange` bigint(20) NOT NULL DEFAULT 0
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- --------------------------------------------------------
+
+--
+-- Table structure for table `privacy`
+--
+
+CREATE TABLE `privacy` (
+  `id` int(11) NOT NULL,
+  `searchable` tinyint(1) NOT NULL DEFAULT 1,
+  `privacymode` int(11) NOT NULL DEFAULT 0,
+  `showavatar` tinyint(1) NOT NULL DEFAULT 1
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+--
+-- Dumping data for table `privacy`
+--
+
+INSERT INTO `privacy` (`id`, `searchable`, `privacymode`, `showavatar`) VALUES
+(1, 1, 0, 1),
+(2, 1, 0, 1);
+
+-- --------------------------------------------------------
+
 --
 -- Table structure for table `upload`
 --
@@ -141,6 +329,103 @@ INSERT INTO `upload` (`id`, `avatar`) VALUES
 ALTER TABLE `accounts`
   ADD PRIMARY KEY (`id`);
 
+--
+-- Indexes for table `actions`
+--
+ALTER TABLE `actions`
+  ADD PRIMARY KEY (`id`);
+
+--
+-- Indexes for table `bulletinqueue`
+--
+ALTER TABLE `bulletinqueue`
+  ADD PRIMARY KEY (`bulletin_id`,`to_id`),
+  ADD KEY `to_id` (`to_id`);
+
+--
+-- Indexes for table `bulletins`
+--
+ALTER TABLE `bulletins`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `from_id` (`from_id`);
+
+--
+-- Indexes for table `contactinfo`
+--
+ALTER TABLE `contactinfo`
+  ADD PRIMARY KEY (`owner_id`,`contact_id`);
+
+--
+-- Indexes for table `directory`
+--
+ALTER TABLE `directory`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `name` (`lname`,`fname`);
+
+--
+-- Indexes for table `mailbox`
+--
+ALTER TABLE `mailbox`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `to_id` (`to_id`);
+
+--
+-- Indexes for table `msn`
+--
+ALTER TABLE `msn`
+  ADD PRIMARY KEY (`id`);
+
+--
+-- Indexes for table `msngroupmembers`
+--
+ALTER TABLE `msngroupmembers`
+  ADD PRIMARY KEY (`owner_id`,`contact_id`,`groupid`);
+
+--
+-- Indexes for table `msngroups`
+--
+ALTER TABLE `msngroups`
+  ADD PRIMARY KEY (`owner_id`,`groupid`);
+
+--
+-- Indexes for table `msnlists`
+--
+ALTER TABLE `msnlists`
+  ADD PRIMARY KEY (`owner_id`,`contact_id`,`list`),
+  ADD KEY `contact_id` (`contact_id`);
+
+--
+-- Indexes for table `msnphones`
+--
+ALTER TABLE `msnphones`
+  ADD PRIMARY KEY (`id`,`type`);
+
+--
+-- Indexes for table `offlinemsgs`
+--
+ALTER TABLE `offlinemsgs`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `to_id` (`to_id`);
+
+--
+-- Indexes for table `offlinereceipts`
+--
+ALTER TABLE `offlinereceipts`
+  ADD PRIMARY KEY (`id`),
+  ADD KEY `from_id` (`from_id`);
+
+--
+-- Indexes for table `presence`
+--
+ALTER TABLE `presence`
+  ADD PRIMARY KEY (`id`);
+
+--
+-- Indexes for table `privacy`
+--
+ALTER TABLE `privacy`
+  ADD PRIMARY KEY (`id`);
+
 --
 -- AUTO_INCREMENT for dumped tables
 --
@@ -150,6 +435,36 @@ ALTER TABLE `accounts`
 --
 ALTER TABLE `accounts`
   MODIFY `id` int(11) NOT NULL AUTO_INCREMENT, AUTO_INCREMENT=3;
+
+--
+-- AUTO_INCREMENT for table `actions`
+--
+ALTER TABLE `actions`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `bulletins`
+--
+ALTER TABLE `bulletins`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `mailbox`
+--
+ALTER TABLE `mailbox`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `offlinemsgs`
+--
+ALTER TABLE `offlinemsgs`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
+
+--
+-- AUTO_INCREMENT for table `offlinereceipts`
+--
+ALTER TABLE `offlinereceipts`
+  MODIFY `id` int(11) NOT NULL AUTO_INCREMENT;
 COMMIT;
 
 /*!40101 SET CHARACTER_SET_CLIENT=@OLD_CHARACTER_SET_CLIENT */;
@@ -0,0 +1,138 @@
+package global
+
+import (
+	"phantom/util"
+	"strings"
+	"time"
+)
+
+const (
+	BulletinMaxSubject = 100
+	BulletinMaxBody    = 2000
+)
+
+// PostBulletin stores a bulletin, queues it for everyone who has the poster on their list and notifies those online
+func PostBulletin(from int, subject string, body string) (Bulletin, bool) {
+	bulletin := Bulletin{
+		FromId:  from,
+		Subject: strings.TrimSpace(subject),
+		Body:    strings.TrimSpace(body),
+		Date:    time.Now().UTC().UnixMilli(),
+	}
+
+	if bulletin.Subject == "" || len(bulletin.Subject) > BulletinMaxSubject || len(bulletin.Body) > BulletinMaxBody {
+		return bulletin, false
+	}
+
+	res, err := util.GetDatabaseHandle().Exec("INSERT INTO bulletins (`from_id`, `subject`, `body`, `date`) VALUES (?, ?, ?, ?)", bulletin.FromId, bulletin.Subject, bulletin.Body, bulletin.Date)
+	if err != nil {
+		util.Error("Store Bulletin -> Uid", "Failed to store bulletin: %s", err.Error())
+		return bulletin, false
+	}
+
+	id, _ := res.LastInsertId()
+	bulletin.Id = int(id)
+
+	var friends []int
+	row, err := util.GetDatabaseHandle().Query("SELECT from_id from contacts WHERE to_id= ?", from)
+	if err != nil {
+		util.Error("Store Bulletin -> Uid", "Failed to get friends: %s", err.Error())
+		return bulletin, true
+	}
+	for row.Next() {
+		var friend int
+		row.Scan(&friend)
+		friends = append(friends, friend)
+	}
+	row.Close()
+
+	for i := 0; i < len(friends); i++ {
+		notified := false
+		if target := GetClientByUserId(friends[i]); target != nil {
+			notified = NotifyBulletin(target, bulletin)
+		}
+
+		queue, err := util.GetDatabaseHandle().Query("INSERT IGNORE INTO bulletinqueue (`bulletin_id`, `to_id`, `notified`) VALUES (?, ?, ?)", bulletin.Id, friends[i], notified)
+		if err != nil {
+			util.Error("Store Bulletin -> Uid", "Failed to queue bulletin: %s", err.Error())
+			continue
+		}
+		queue.Close()
+	}
+
+	return bulletin, true
+}
+
+// bulletins from the user and everyone on their list, newest first
+func GetBulletinFeed(uid int, limit int) []Bulletin {
+	var bulletins []Bulletin
+
+	row, err := util.GetDatabaseHandle().Query("SELECT b.id, b.from_id, b.subject, b.body, b.date from bulletins b WHERE b.from_id= ? OR b.from_id IN (SELECT to_id from contacts WHERE from_id= ?) ORDER BY b.date DESC LIMIT ?", uid, uid, limit)
+	if err != nil {
+		util.Error("Fetch Bulletins -> Uid", "Failed to get bulletin feed: %s", err.Error())
+		return bulletins
+	}
+
+	for row.Next() {
+		var bulletin Bulletin
+		row.Scan(&bulletin.Id, &bulletin.FromId, &bulletin.Subject, &bulletin.Body, &bulletin.Date)
+		bulletins = append(bulletins, bulletin)
+	}
+	row.Close()
+
+	return bulletins
+}
+
+// queued bulletins the user was not told about yet
+func GetUnnotifiedBulletins(uid int) []Bulletin {
+	var bulletins []Bulletin
+
+	row, err := util.GetDatabaseHandle().Query("SELECT b.id, b.from_id, b.subject, b.body, b.date from bulletins b INNER JOIN bulletinqueue q ON q.bulletin_id = b.id WHERE q.to_id= ? AND q.notified = 0 ORDER BY b.date", uid)
+	if err != nil {
+		util.Error("Fetch Bulletins -> Uid", "Failed to get pending bulletins: %s", err.Error())
+		return bulletins
+	}
+
+	for row.Next() {
+		var bulletin Bulletin
+		row.Scan(&bulletin.Id, &bulletin.FromId, &bulletin.Subject, &bulletin.Body, &bulletin.Date)
+		bulletins = append(bulletins, bulletin)
+	}
+	row.Close()
+
+	return bulletins
+}
+
+func MarkBulletinsNotified(uid int) {
+	res, err := util.GetDatabaseHandle().Query("UPDATE bulletinqueue SET notified= 1 WHERE to_id= ?", uid)
+	if err != nil {
+		util.Error("Update Bulletins -> Uid", "Failed to mark bulletins: %s", err.Error())
+		return
+	}
+	res.Close()
+}
+
+func CountUnreadBulletins(uid int) int {
+	var count int
+
+	row, err := util.GetDatabaseHandle().Query("SELECT COUNT(*) from bulletinqueue WHERE to_id= ?", uid)
+	if err != nil {
+		util.Error("Fetch Bulletins -> Uid", "Failed to count bulletins: %s", err.Error())
+		return 0
+	}
+	row.Next()
+	row.Scan(&count)
+	row.Close()
+
+	return count
+}
+
+// reading the feed clears the queue
+func MarkBulletinsRead(uid int) {
+	res, err := util.GetDatabaseHandle().Query("DELETE from bulletinqueue WHERE to_id= ?", uid)
+	if err != nil {
+		util.Error("Update Bulletins -> Uid", "Failed to clear bulletins: %s", err.Error())
+		return
+	}
+	res.Close()
+}
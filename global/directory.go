@@ -0,0 +1,92 @@
+package global
+
+import (
+	"phantom/util"
+	"strings"
+)
+
+const DirectoryMaxLength = 64
+
+// DirectoryEntry is what a user shares with the member directory, nothing is searchable until Listed is set
+type DirectoryEntry struct {
+	UserId    int
+	FirstName string
+	LastName  string
+	City      string
+	State     string
+	Country   string
+	Listed    bool
+}
+
+// GetDirectoryEntry returns the users directory entry, users who never saved one get an empty unlisted entry
+func GetDirectoryEntry(uid int) DirectoryEntry {
+	entry := DirectoryEntry{UserId: uid}
+
+	row, err := util.GetDatabaseHandle().Query("SELECT fname, lname, city, state, country, listed from directory WHERE id= ?", uid)
+	if err != nil {
+		util.Error("Get Directory -> Uid", "Failed to get directory entry: %s", err.Error())
+		return entry
+	}
+
+	if row.Next() {
+		row.Scan(&entry.FirstName, &entry.LastName, &entry.City, &entry.State, &entry.Country, &entry.Listed)
+	}
+	row.Close()
+
+	return entry
+}
+
+func SetDirectoryEntry(entry DirectoryEntry) bool {
+	fields := []*string{&entry.FirstName, &entry.LastName, &entry.City, &entry.State, &entry.Country}
+	for i := 0; i < len(fields); i++ {
+		*fields[i] = strings.TrimSpace(*fields[i])
+		if len(*fields[i]) > DirectoryMaxLength {
+			return false
+		}
+	}
+	entry.Country = strings.ToUpper(entry.Country)
+
+	res, err := util.GetDatabaseHandle().Query("INSERT into directory (`id`, `fname`, `lname`, `city`, `state`, `country`, `listed`) VALUES (?, ?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE fname= VALUES(fname), lname= VALUES(lname), city= VALUES(city), state= VALUES(state), country= VALUES(country), listed= VALUES(listed)", entry.UserId, entry.FirstName, entry.LastName, entry.City, entry.State, entry.Country, entry.Listed)
+	if err != nil {
+		util.Error("Set Directory -> Uid", "Failed to store directory entry: %s", err.Error())
+		return false
+	}
+	res.Close()
+
+	return true
+}
+
+// SearchDirectory finds listed users, empty criteria match everything, at most limit entries are returned
+func SearchDirectory(criteria DirectoryEntry, limit int) []DirectoryEntry {
+	var entries []DirectoryEntry
+
+	query := "SELECT id, fname, lname, city, state, country from directory WHERE listed= 1"
+	var args []interface{}
+
+	columns := []string{"fname", "lname", "city", "state", "country"}
+	values := []string{criteria.FirstName, criteria.LastName, criteria.City, criteria.State, criteria.Country}
+	for i := 0; i < len(columns); i++ {
+		if values[i] == "" {
+			continue
+		}
+		query += " AND " + columns[i] + "= ?"
+		args = append(args, values[i])
+	}
+	query += " ORDER BY lname, fname, id LIMIT ?"
+	args = append(args, limit)
+
+	row, err := util.GetDatabaseHandle().Query(query, args...)
+	if err != nil {
+		util.Error("Search Directory", "Failed to search directory: %s", err.Error())
+		return entries
+	}
+
+	for row.Next() {
+		entry := DirectoryEntry{Listed: true}
+		row.Scan(&entry.UserId, &entry.FirstName, &entry.LastName, &entry.City, &entry.State, &entry.Country)
+		entries = append(entries, entry)
+	}
+	row.Close()
+
+	return entries
+}
@@ -7,7 +7,19 @@ import (
 )
 
 func AddClient(client *Client) {
+	ClientsLock.Lock()
 	Clients = append(Clients, client)
+	ClientsLock.Unlock()
+}
+
+// copy of the client list for notifying, safe to walk while clients come and go
+func SnapshotClients() []*Client {
+	ClientsLock.Lock()
+	defer ClientsLock.Unlock()
+
+	targets := make([]*Client, len(Clients))
+	copy(targets, Clients)
+	return targets
 }
 
 func RemoveClient(s []*Client, i int) []*Client {
@@ -16,6 +28,9 @@ func RemoveClient(s []*Client, i int) []*Client {
 }
 
 func GetClient(username string) *Client {
+	ClientsLock.Lock()
+	defer ClientsLock.Unlock()
+
 	for i := 0; i < len(Clients); i++ {
 		if Clients[i].Account.Email == username {
 			return Clients[i]
@@ -25,6 +40,19 @@ func GetClient(username string) *Client {
 	return nil
 }
 
+func GetClientByUserId(uid int) *Client {
+	ClientsLock.Lock()
+	defer ClientsLock.Unlock()
+
+	for i := 0; i < len(Clients); i++ {
+		if Clients[i].Account.UserId == uid {
+			return Clients[i]
+		}
+	}
+
+	return nil
+}
+
 func GetUserDataFromEmail(email string) (Account, bool) {
 
 	var acc Account
@@ -103,6 +131,24 @@ func GetUserDataFromUserId(uid int) (Account, bool) {
 	return acc, true
 }
 
+func IsContact(from int, to int) bool {
+
+	var count int
+
+	row, err := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id= ? AND to_id= ?", from, to)
+
+	if err != nil {
+		util.Error("Fetch Contact -> Uid", "Failed to check contact: %s", err.Error())
+		return false
+	}
+
+	row.Next()
+	row.Scan(&count)
+	row.Close()
+
+	return count > 0
+}
+
 func GetUploadDataFromUserId(uid int) (Upload, bool) {
 
 	var upl Upload
@@ -120,3 +166,108 @@ func GetUploadDataFromUserId(uid int) (Upload, bool) {
 
 	return upl, true
 }
+
+func GetPrivacyFromUserId(uid int) (Privacy, bool) {
+
+	prv := Privacy{
+		UserId:     uid,
+		Searchable: true,
+		ShowAvatar: true,
+	}
+
+	row, err := util.GetDatabaseHandle().Query("SELECT searchable, privacymode, showavatar from privacy WHERE id= ?", uid)
+
+	if err != nil {
+		util.Error("Fetch PrivacyData -> Uid", "Failed to get privacy settings: %s", err.Error())
+		return prv, false
+	}
+
+	if row.Next() {
+		row.Scan(&prv.Searchable, &prv.PrivacyMode, &prv.ShowAvatar)
+	}
+	row.Close()
+
+	return prv, true
+}
+
+func GetContactInfo(owner int, contact int) (ContactInfo, bool) {
+
+	info := ContactInfo{
+		OwnerId:   owner,
+		ContactId: contact,
+	}
+
+	row, err := util.GetDatabaseHandle().Query("SELECT nickname, notes, nameselect from contactinfo WHERE owner_id= ? AND contact_id= ?", owner, contact)
+
+	if err != nil {
+		util.Error("Fetch ContactInfo -> Uid", "Failed to get contact info: %s", err.Error())
+		return info, false
+	}
+
+	if row.Next() {
+		row.Scan(&info.NickName, &info.Notes, &info.NameSelect)
+	}
+	row.Close()
+
+	return info, true
+}
+
+func SetContactInfo(info ContactInfo) bool {
+
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO contactinfo (`owner_id`, `contact_id`, `nickname`, `notes`, `nameselect`) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE nickname= VALUES(nickname), notes= VALUES(notes), nameselect= VALUES(nameselect)",
+		info.OwnerId, info.ContactId, info.NickName, info.Notes, info.NameSelect)
+
+	if err != nil {
+		util.Error("Store ContactInfo -> Uid", "Failed to store contact info: %s", err.Error())
+		return false
+	}
+	res.Close()
+
+	return true
+}
+
+// contact info goes with the contact, removing them from the list drops it
+func DeleteContactInfo(owner int, contact int) {
+
+	res, err := util.GetDatabaseHandle().Query("DELETE from contactinfo WHERE owner_id= ? AND contact_id= ?", owner, contact)
+
+	if err != nil {
+		util.Error("Delete ContactInfo -> Uid", "Failed to delete contact info: %s", err.Error())
+		return
+	}
+	res.Close()
+}
+
+// the contact list version lets msnp clients skip a full resync when nothing changed
+func GetListVersion(uid int) int {
+
+	var version int
+
+	row, err := util.GetDatabaseHandle().Query("SELECT clversion from msn WHERE id= ?", uid)
+
+	if err != nil {
+		util.Error("Fetch ListVersion -> Uid", "Failed to get list version: %s", err.Error())
+		return 0
+	}
+
+	if row.Next() {
+		row.Scan(&version)
+	}
+	row.Close()
+
+	return version
+}
+
+// IncrementListVersion has to be called for every change to a user's lists, including their reverse list
+func IncrementListVersion(uid int) int {
+
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO msn (`id`, `clversion`) VALUES (?, 1) ON DUPLICATE KEY UPDATE clversion= clversion + 1", uid)
+
+	if err != nil {
+		util.Error("Update ListVersion -> Uid", "Failed to increment list version: %s", err.Error())
+		return GetListVersion(uid)
+	}
+	res.Close()
+
+	return GetListVersion(uid)
+}
@@ -0,0 +1,100 @@
+package global
+
+import "sync"
+
+// translators deliver protocol neutral events to clients of another protocol
+type ActionTranslator func(target *Client, event ActionEvent) bool
+
+var actionTranslators = map[string]ActionTranslator{}
+var hooksLock sync.Mutex
+
+func RegisterActionTranslator(client string, translator ActionTranslator) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	actionTranslators[client] = translator
+}
+
+func DeliverAction(target *Client, event ActionEvent) bool {
+	hooksLock.Lock()
+	translator, ok := actionTranslators[target.Client]
+	hooksLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return translator(target, event)
+}
+
+// notifiers tell an online client about a new bulletin from one of their friends
+type BulletinNotifier func(target *Client, bulletin Bulletin) bool
+
+var bulletinNotifiers = map[string]BulletinNotifier{}
+
+func RegisterBulletinNotifier(client string, notifier BulletinNotifier) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	bulletinNotifiers[client] = notifier
+}
+
+func NotifyBulletin(target *Client, bulletin Bulletin) bool {
+	hooksLock.Lock()
+	notifier, ok := bulletinNotifiers[target.Client]
+	hooksLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return notifier(target, bulletin)
+}
+
+// profile notifiers push a changed profile of one of the target's buddies
+type ProfileNotifier func(target *Client, uid int) bool
+
+var profileNotifiers = map[string]ProfileNotifier{}
+
+func RegisterProfileNotifier(client string, notifier ProfileNotifier) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	profileNotifiers[client] = notifier
+}
+
+func NotifyProfile(target *Client, uid int) bool {
+	hooksLock.Lock()
+	notifier, ok := profileNotifiers[target.Client]
+	hooksLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return notifier(target, uid)
+}
+
+// mail notifiers tell an online client about new mail in their local mailbox
+type MailNotifier func(target *Client, mail Mail) bool
+
+var mailNotifiers = map[string]MailNotifier{}
+
+func RegisterMailNotifier(client string, notifier MailNotifier) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+
+	mailNotifiers[client] = notifier
+}
+
+func NotifyMail(target *Client, mail Mail) bool {
+	hooksLock.Lock()
+	notifier, ok := mailNotifiers[target.Client]
+	hooksLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return notifier(target, mail)
+}
@@ -0,0 +1,125 @@
+package global
+
+import (
+	"phantom/util"
+	"strings"
+	"time"
+)
+
+const (
+	MailMaxFrom    = 255
+	MailMaxSubject = 255
+	MailMaxBody    = 10000
+)
+
+// DeliverMail drops a message into the users local mailbox and notifies their online clients
+func DeliverMail(to int, fromname string, fromaddr string, subject string, body string) (Mail, bool) {
+	mail := Mail{
+		ToId:     to,
+		FromName: strings.TrimSpace(fromname),
+		FromAddr: strings.TrimSpace(fromaddr),
+		Subject:  strings.TrimSpace(subject),
+		Body:     body,
+		Date:     time.Now().UTC().UnixMilli(),
+	}
+
+	if mail.FromAddr == "" || len(mail.FromName) > MailMaxFrom || len(mail.FromAddr) > MailMaxFrom || len(mail.Subject) > MailMaxSubject || len(mail.Body) > MailMaxBody {
+		return mail, false
+	}
+	if mail.FromName == "" {
+		mail.FromName = mail.FromAddr
+	}
+
+	res, err := util.GetDatabaseHandle().Exec("INSERT INTO mailbox (`to_id`, `from_name`, `from_addr`, `subject`, `body`, `date`, `seen`) VALUES (?, ?, ?, ?, ?, ?, 0)", mail.ToId, mail.FromName, mail.FromAddr, mail.Subject, mail.Body, mail.Date)
+	if err != nil {
+		util.Error("Store Mail -> Uid", "Failed to store mail: %s", err.Error())
+		return mail, false
+	}
+
+	id, _ := res.LastInsertId()
+	mail.Id = int(id)
+
+	targets := SnapshotClients()
+
+	for i := 0; i < len(targets); i++ {
+		if targets[i].Account.UserId == to {
+			NotifyMail(targets[i], mail)
+		}
+	}
+
+	return mail, true
+}
+
+// the users mail, newest first
+func GetMailbox(uid int, limit int) []Mail {
+	var mails []Mail
+
+	row, err := util.GetDatabaseHandle().Query("SELECT id, to_id, from_name, from_addr, subject, body, date, seen from mailbox WHERE to_id= ? ORDER BY date DESC LIMIT ?", uid, limit)
+	if err != nil {
+		util.Error("Fetch Mail -> Uid", "Failed to get mailbox: %s", err.Error())
+		return mails
+	}
+
+	for row.Next() {
+		var mail Mail
+		row.Scan(&mail.Id, &mail.ToId, &mail.FromName, &mail.FromAddr, &mail.Subject, &mail.Body, &mail.Date, &mail.Seen)
+		mails = append(mails, mail)
+	}
+	row.Close()
+
+	return mails
+}
+
+// only returns mail that belongs to the user
+func GetMail(uid int, id int) (Mail, bool) {
+	var mail Mail
+
+	row, err := util.GetDatabaseHandle().Query("SELECT id, to_id, from_name, from_addr, subject, body, date, seen from mailbox WHERE id= ? AND to_id= ?", id, uid)
+	if err != nil {
+		util.Error("Fetch Mail -> Uid", "Failed to get mail: %s", err.Error())
+		return mail, false
+	}
+
+	found := row.Next()
+	if found {
+		row.Scan(&mail.Id, &mail.ToId, &mail.FromName, &mail.FromAddr, &mail.Subject, &mail.Body, &mail.Date, &mail.Seen)
+	}
+	row.Close()
+
+	return mail, found
+}
+
+func CountUnreadMail(uid int) int {
+	count := 0
+
+	row, err := util.GetDatabaseHandle().Query("SELECT COUNT(*) from mailbox WHERE to_id= ? AND seen= 0", uid)
+	if err != nil {
+		util.Error("Fetch Mail -> Uid", "Failed to count unread mail: %s", err.Error())
+		return count
+	}
+
+	if row.Next() {
+		row.Scan(&count)
+	}
+	row.Close()
+
+	return count
+}
+
+func MarkMailRead(uid int, id int) {
+	res, err := util.GetDatabaseHandle().Query("UPDATE mailbox SET seen= 1 WHERE id= ? AND to_id= ?", id, uid)
+	if err != nil {
+		util.Error("Update Mail -> Uid", "Failed to mark mail as read: %s", err.Error())
+		return
+	}
+	res.Close()
+}
+
+func DeleteMail(uid int, id int) {
+	res, err := util.GetDatabaseHandle().Query("DELETE from mailbox WHERE id= ? AND to_id= ?", id, uid)
+	if err != nil {
+		util.Error("Delete Mail -> Uid", "Failed to delete mail: %s", err.Error())
+		return
+	}
+	res.Close()
+}
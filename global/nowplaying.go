@@ -0,0 +1,62 @@
+package global
+
+import (
+	"phantom/util"
+	"strings"
+)
+
+const NowPlayingMaxLength = 255
+
+// SetNowPlaying stores the user's current band and song and pushes it to their online mutual contacts
+func SetNowPlaying(uid int, band string, song string) bool {
+	band = strings.TrimSpace(band)
+	song = strings.TrimSpace(song)
+
+	if len(band) > NowPlayingMaxLength || len(song) > NowPlayingMaxLength {
+		return false
+	}
+
+	res, err := util.GetDatabaseHandle().Query("UPDATE myspace SET bandname= ?, songname= ? WHERE id= ?", band, song, uid)
+	if err != nil {
+		util.Error("Store NowPlaying -> Uid", "Failed to store now playing: %s", err.Error())
+		return false
+	}
+	res.Close()
+
+	mutual := getMutualContacts(uid)
+	if len(mutual) == 0 {
+		return true
+	}
+
+	targets := SnapshotClients()
+
+	for i := 0; i < len(targets); i++ {
+		if !mutual[targets[i].Account.UserId] {
+			continue
+		}
+		NotifyProfile(targets[i], uid)
+	}
+
+	return true
+}
+
+// everyone who has uid on their list and is on uid's list, in one query
+func getMutualContacts(uid int) map[int]bool {
+	mutual := make(map[int]bool)
+
+	res, err := util.GetDatabaseHandle().Query("SELECT c.from_id from contacts c INNER JOIN contacts r ON r.from_id = c.to_id AND r.to_id = c.from_id WHERE c.to_id= ? AND c.from_id != c.to_id", uid)
+	if err != nil {
+		util.Error("Fetch Contacts -> Uid", "Failed to fetch mutual contacts: %s", err.Error())
+		return mutual
+	}
+
+	for res.Next() {
+		var id int
+		if err := res.Scan(&id); err == nil {
+			mutual[id] = true
+		}
+	}
+	res.Close()
+
+	return mutual
+}
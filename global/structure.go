@@ -1,6 +1,9 @@
 package global
 
-import "net"
+import (
+	"net"
+	"sync"
+)
 
 type Client struct {
 	Connection  net.Conn
@@ -26,15 +29,70 @@ type Contact struct {
 }
 
 type OfflineMsg struct {
+	Id      int
 	FromId  int
 	ToId    int
-	Date    int
+	Date    int64
 	Message string
 }
 
+const (
+	ActionText = iota
+	ActionTyping
+	ActionStopTyping
+	ActionZap
+	ActionUnknown
+)
+
+type ActionEvent struct {
+	Kind int
+	From Account
+	ToId int
+	Zap  int
+	Text string
+}
+
+type Privacy struct {
+	UserId      int
+	Searchable  bool
+	PrivacyMode int
+	ShowAvatar  bool
+}
+
+// per-owner metadata about a contact, shared by every protocol
+type ContactInfo struct {
+	OwnerId    int
+	ContactId  int
+	NickName   string
+	Notes      string
+	NameSelect int
+}
+
+type Bulletin struct {
+	Id      int
+	FromId  int
+	Subject string
+	Body    string
+	Date    int64
+}
+
+type Mail struct {
+	Id       int
+	ToId     int
+	FromName string
+	FromAddr string
+	Subject  string
+	Body     string
+	Date     int64
+	Seen     bool
+}
+
 type Upload struct {
 	UserId int
 	Avatar string
 }
 
 var Clients []*Client
+
+// guards Clients against the socket handlers adding and removing while someone walks the list
+var ClientsLock sync.Mutex
@@ -0,0 +1,61 @@
+package global
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type token struct {
+	kind    string
+	userid  int
+	expires time.Time
+}
+
+var tokens = map[string]token{}
+var tokensLock sync.Mutex
+
+// IssueToken hands out a random token that resolves to the user until it expires
+func IssueToken(kind string, uid int, ttl time.Duration) string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	key := hex.EncodeToString(raw)
+
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	now := time.Now()
+	for k, t := range tokens {
+		if now.After(t.expires) {
+			delete(tokens, k)
+		}
+	}
+
+	tokens[key] = token{
+		kind:    kind,
+		userid:  uid,
+		expires: now.Add(ttl),
+	}
+
+	return key
+}
+
+func LookupToken(kind string, key string) (int, bool) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	t, ok := tokens[key]
+	if !ok || t.kind != kind || time.Now().After(t.expires) {
+		return 0, false
+	}
+
+	return t.userid, true
+}
+
+func RevokeToken(key string) {
+	tokensLock.Lock()
+	defer tokensLock.Unlock()
+
+	delete(tokens, key)
+}
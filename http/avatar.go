@@ -0,0 +1,69 @@
+package http
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"phantom/util"
+)
+
+func HandleAvatarEditor(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		message = storeAvatar(r, acc.UserId)
+	}
+
+	prf, _ := getProfile(acc.UserId)
+	renderPage(w, `{{if .Data.AvatarType}}<img src="/pfp/id={{.Data.Account.UserId}}.{{.Data.AvatarType}}" alt="picture"/>{{else}}<p>You have no picture yet.</p>{{end}}
+<form method="post" action="/avatar" enctype="multipart/form-data">
+<input type="hidden" name="token" value="{{.Token}}"/>
+<p><input type="file" name="picture" accept="image/gif,image/png,image/jpeg"/></p>
+<p><input type="submit" value="Upload"/></p>
+</form>`, page{Title: "Change Picture", Token: token, User: acc, Message: message, Data: prf})
+}
+
+func storeAvatar(r *http.Request, uid int) string {
+	file, _, err := r.FormFile("picture")
+	if err != nil {
+		return "Please choose a picture to upload."
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, util.AvatarMaxSize+1))
+	if err != nil {
+		return "The picture could not be read."
+	}
+	if len(image) > util.AvatarMaxSize {
+		return "The picture is too large."
+	}
+
+	format, width, height, err := util.DetectImage(image)
+	if err != nil {
+		return "The picture is not a valid GIF, PNG or JPEG image."
+	}
+	if width > util.AvatarMaxDimension || height > util.AvatarMaxDimension {
+		return "The picture dimensions are too large."
+	}
+
+	res, err := util.GetDatabaseHandle().Query("UPDATE upload SET avatar= ? WHERE id= ?", base64.StdEncoding.EncodeToString(image), uid)
+	if err != nil {
+		util.Error("WebAPI -> storeAvatar", err.Error())
+		return "The picture could not be saved."
+	}
+	res.Close()
+
+	res, err = util.GetDatabaseHandle().Query("UPDATE myspace SET avatartype= ? WHERE id= ?", format, uid)
+	if err != nil {
+		util.Error("WebAPI -> storeAvatar", err.Error())
+		return "The picture could not be saved."
+	}
+	res.Close()
+
+	util.Debug("WebAPI -> storeAvatar", "Stored %dx%d %s picture for %d", width, height, format, uid)
+	return "Your picture has been changed."
+}
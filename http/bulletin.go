@@ -0,0 +1,108 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"time"
+)
+
+const bulletinFeedSize = 50
+
+type bulletinEntry struct {
+	From    string
+	FromId  int
+	Date    string
+	Subject string
+	Body    string
+}
+
+func HandleBulletins(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		if _, ok := global.PostBulletin(acc.UserId, r.FormValue("subject"), r.FormValue("body")); ok {
+			message = "Your bulletin has been posted."
+		} else {
+			message = fmt.Sprintf("Bulletins need a subject of up to %d and a body of up to %d characters.", global.BulletinMaxSubject, global.BulletinMaxBody)
+		}
+	}
+
+	var entries []bulletinEntry
+	bulletins := global.GetBulletinFeed(acc.UserId, bulletinFeedSize)
+	for i := 0; i < len(bulletins); i++ {
+		from, _ := global.GetUserDataFromUserId(bulletins[i].FromId)
+		entries = append(entries, bulletinEntry{
+			From:    from.Screenname,
+			FromId:  bulletins[i].FromId,
+			Date:    time.UnixMilli(bulletins[i].Date).UTC().Format("2006-01-02 15:04"),
+			Subject: bulletins[i].Subject,
+			Body:    bulletins[i].Body,
+		})
+	}
+	global.MarkBulletinsRead(acc.UserId)
+
+	renderPage(w, `<form method="post" action="/bulletins">
+<input type="hidden" name="token" value="{{.Token}}"/>
+<p>Subject <input name="subject"/></p>
+<p><textarea name="body" rows="4" cols="50"></textarea></p>
+<p><input type="submit" value="Post Bulletin"/></p>
+</form>
+{{if .Data}}{{range .Data}}<hr/>
+<p><b>{{.Subject}}</b> by <a href="/profile/{{.FromId}}?token={{$.Token}}">{{.From}}</a> ({{.Date}})</p>
+<p>{{.Body}}</p>
+{{end}}{{else}}<p>There are no bulletins yet.</p>{{end}}`, page{Title: "Bulletins", Token: token, User: acc, Message: message, Data: entries})
+}
+
+// the admin key is only taken from the X-Admin-Key header or a POST form field, never the query
+// string, so it does not end up in access logs or browser history
+func checkAdminKey(r *http.Request) bool {
+	key := util.GetAdminKey()
+	if key == "" {
+		return false
+	}
+
+	given := r.Header.Get("X-Admin-Key")
+	if given == "" {
+		given = r.PostFormValue("key")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(given), []byte(key)) == 1
+}
+
+// POST /admin/bulletin with key, from, subject and body
+func HandleAdminBulletin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminKey(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	from, err := strconv.Atoi(r.FormValue("from"))
+	if acc, ok := global.GetUserDataFromUserId(from); err != nil || !ok || acc.UserId == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown user\n"))
+		return
+	}
+
+	bulletin, ok := global.PostBulletin(from, r.FormValue("subject"), r.FormValue("body"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid bulletin\n"))
+		return
+	}
+
+	util.Log("WebAPI -> HandleAdminBulletin", "Posted bulletin %d for user %d", bulletin.Id, from)
+	w.Write([]byte(strconv.Itoa(bulletin.Id) + "\n"))
+}
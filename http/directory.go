@@ -0,0 +1,46 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"phantom/global"
+)
+
+// lets users opt in to the member directory that msn clients search with FND
+func HandleDirectory(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		entry := global.DirectoryEntry{
+			UserId:    acc.UserId,
+			FirstName: r.FormValue("fname"),
+			LastName:  r.FormValue("lname"),
+			City:      r.FormValue("city"),
+			State:     r.FormValue("state"),
+			Country:   r.FormValue("country"),
+			Listed:    r.FormValue("listed") == "on",
+		}
+
+		if global.SetDirectoryEntry(entry) {
+			message = "Your directory listing has been saved."
+		} else {
+			message = fmt.Sprintf("Each field can be up to %d characters long.", global.DirectoryMaxLength)
+		}
+	}
+
+	entry := global.GetDirectoryEntry(acc.UserId)
+	renderPage(w, `{{with .Data}}<form method="post" action="/directory">
+<input type="hidden" name="token" value="{{$.Token}}"/>
+<p>First Name <input name="fname" value="{{.FirstName}}"/></p>
+<p>Last Name <input name="lname" value="{{.LastName}}"/></p>
+<p>City <input name="city" value="{{.City}}"/></p>
+<p>State <input name="state" value="{{.State}}"/></p>
+<p>Country <input name="country" value="{{.Country}}" maxlength="2"/></p>
+<p><input type="checkbox" name="listed"{{if .Listed}} checked{{end}}/> List me in the member directory</p>
+<p><input type="submit" value="Save"/></p>
+</form>{{end}}`, page{Title: "Directory", Token: token, User: acc, Message: message, Data: entry})
+}
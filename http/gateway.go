@@ -0,0 +1,300 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"phantom/msnp"
+	"phantom/util"
+	"sync"
+	"time"
+)
+
+/*
+	MSNP over http polling
+
+	POST /gateway/gateway.dll?Action=open&Server=NS|SB&IP=<host> -> opens a session
+	POST /gateway/gateway.dll?SessionID=<id>                      -> sends commands
+	POST /gateway/gateway.dll?Action=poll&SessionID=<id>          -> only collects traffic
+
+	every response carries X-MSN-Messenger with the session id and whatever the
+	server wrote since the last request. sessions are handed to the regular NS
+	and SB handlers through gatewayConn, so those don't know about http at all
+*/
+
+const (
+	gatewayIdleTimeout = 2 * time.Minute
+	gatewayReplyWait   = 250 * time.Millisecond
+	gatewayCleanup     = 30 * time.Second
+
+	// request bodies the handlers haven't read yet, a session going past this is closed
+	gatewayMaxInbound = 64 * 1024
+
+	// open sessions allowed at once, per remote address and in total
+	gatewayMaxPerAddress = 8
+	gatewayMaxSessions   = 512
+)
+
+var errGatewayClosed = errors.New("gateway session closed")
+var errGatewayServer = errors.New("unknown gateway server")
+var errGatewayFull = errors.New("too many gateway sessions")
+
+type gatewayAddr string
+
+func (addr gatewayAddr) Network() string { return "http" }
+func (addr gatewayAddr) String() string  { return string(addr) }
+
+// gatewayConn is a net.Conn fed by request bodies and drained by responses
+type gatewayConn struct {
+	lock     sync.Mutex
+	readable *sync.Cond
+	written  chan struct{}
+	inbound  []byte
+	outbound []byte
+	closed   bool
+	remote   gatewayAddr
+}
+
+func newGatewayConn(remote string) *gatewayConn {
+	conn := &gatewayConn{
+		written: make(chan struct{}, 1),
+		remote:  gatewayAddr(remote),
+	}
+	conn.readable = sync.NewCond(&conn.lock)
+	return conn
+}
+
+func (conn *gatewayConn) Read(b []byte) (int, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	for len(conn.inbound) == 0 && !conn.closed {
+		conn.readable.Wait()
+	}
+	if len(conn.inbound) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(b, conn.inbound)
+	conn.inbound = conn.inbound[n:]
+	return n, nil
+}
+
+func (conn *gatewayConn) Write(b []byte) (int, error) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if conn.closed {
+		return 0, errGatewayClosed
+	}
+	conn.outbound = append(conn.outbound, b...)
+
+	select {
+	case conn.written <- struct{}{}:
+	default:
+	}
+
+	return len(b), nil
+}
+
+func (conn *gatewayConn) Close() error {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	conn.closed = true
+	conn.readable.Broadcast()
+	return nil
+}
+
+func (conn *gatewayConn) LocalAddr() net.Addr                { return gatewayAddr(util.GetRootUrl()) }
+func (conn *gatewayConn) RemoteAddr() net.Addr               { return conn.remote }
+func (conn *gatewayConn) SetDeadline(t time.Time) error      { return nil }
+func (conn *gatewayConn) SetReadDeadline(t time.Time) error  { return nil }
+func (conn *gatewayConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// false when the data doesn't fit anymore, the session is closed then
+func (conn *gatewayConn) push(data []byte) bool {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	if len(conn.inbound)+len(data) > gatewayMaxInbound {
+		conn.closed = true
+		conn.readable.Broadcast()
+		return false
+	}
+
+	conn.inbound = append(conn.inbound, data...)
+	conn.readable.Broadcast()
+	return true
+}
+
+// returns everything written so far and whether the session is gone
+func (conn *gatewayConn) drain() ([]byte, bool) {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+
+	data := conn.outbound
+	conn.outbound = nil
+	return data, conn.closed
+}
+
+type gatewaySession struct {
+	conn     *gatewayConn
+	server   string
+	host     string
+	lastpoll time.Time
+}
+
+var gatewaySessions = map[string]*gatewaySession{}
+var gatewayLock sync.Mutex
+var gatewayCleanupOnce sync.Once
+
+func generateGatewaySessionId() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// sessions that stopped polling are closed, the handlers then clean up like after a dropped tcp connection
+func handleGatewayCleanup() {
+	for {
+		time.Sleep(gatewayCleanup)
+
+		gatewayLock.Lock()
+		for id, session := range gatewaySessions {
+			if time.Since(session.lastpoll) > gatewayIdleTimeout {
+				util.Debug("WebAPI -> handleGatewayCleanup", "Closing idle gateway session %s", id)
+				session.conn.Close()
+				delete(gatewaySessions, id)
+			}
+		}
+		gatewayLock.Unlock()
+	}
+}
+
+// the port is left out, a client opens a new connection for every session
+func getGatewayHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// gatewayLock has to be held
+func countGatewaySessions(host string) int {
+	count := 0
+	for _, session := range gatewaySessions {
+		if session.host == host {
+			count++
+		}
+	}
+	return count
+}
+
+func openGatewaySession(r *http.Request) (string, *gatewaySession, error) {
+	server := r.URL.Query().Get("Server")
+	if server != "NS" && server != "SB" {
+		return "", nil, errGatewayServer
+	}
+
+	id := generateGatewaySessionId()
+	session := &gatewaySession{
+		conn:     newGatewayConn(r.RemoteAddr),
+		server:   server,
+		host:     getGatewayHost(r),
+		lastpoll: time.Now(),
+	}
+
+	gatewayLock.Lock()
+	if len(gatewaySessions) >= gatewayMaxSessions || countGatewaySessions(session.host) >= gatewayMaxPerAddress {
+		gatewayLock.Unlock()
+		util.Log("WebAPI -> HandleGateway", "Refused %s gateway session for %s, too many open sessions", server, r.RemoteAddr)
+		return "", nil, errGatewayFull
+	}
+	gatewaySessions[id] = session
+	gatewayLock.Unlock()
+
+	if server == "NS" {
+		go msnp.ServeNotification(session.conn)
+	} else {
+		go msnp.ServeSwitchboard(session.conn)
+	}
+
+	util.Log("WebAPI -> HandleGateway", "Opened %s gateway session for %s", server, r.RemoteAddr)
+	return id, session, nil
+}
+
+func HandleGateway(w http.ResponseWriter, r *http.Request) {
+	gatewayCleanupOnce.Do(func() { go handleGatewayCleanup() })
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var id string
+	var session *gatewaySession
+	var ok bool
+
+	if r.URL.Query().Get("Action") == "open" {
+		var err error
+		id, session, err = openGatewaySession(r)
+		if err == errGatewayFull {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		ok = err == nil
+	} else {
+		id = r.URL.Query().Get("SessionID")
+		gatewayLock.Lock()
+		session, ok = gatewaySessions[id]
+		gatewayLock.Unlock()
+	}
+
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 65536))
+
+	gatewayLock.Lock()
+	session.lastpoll = time.Now()
+	gatewayLock.Unlock()
+
+	if len(body) > 0 {
+		// forget writes that happened before this request, they are drained below either way
+		select {
+		case <-session.conn.written:
+		default:
+		}
+
+		if session.conn.push(body) {
+			// give the handlers a moment so the reply goes out with this response instead of the next poll
+			select {
+			case <-session.conn.written:
+			case <-time.After(gatewayReplyWait):
+			}
+		} else {
+			util.Log("WebAPI -> HandleGateway", "Closing gateway session %s, too much unread data", id)
+		}
+	}
+
+	data, closed := session.conn.drain()
+
+	header := "SessionID=" + id + "; GW-IP=" + util.GetRootUrl()
+	if closed {
+		header = "Session=close; SessionID=" + id
+		gatewayLock.Lock()
+		delete(gatewaySessions, id)
+		gatewayLock.Unlock()
+	}
+
+	w.Header().Set("X-MSN-Messenger", header)
+	w.Header().Set("Content-Type", "application/x-msn-messenger")
+	w.Write(data)
+}
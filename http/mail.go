@@ -0,0 +1,113 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"time"
+)
+
+const mailboxSize = 50
+
+type mailEntry struct {
+	Id       int
+	From     string
+	FromAddr string
+	Date     string
+	Subject  string
+	Body     string
+	Seen     bool
+}
+
+func newMailEntry(mail global.Mail) mailEntry {
+	return mailEntry{
+		Id:       mail.Id,
+		From:     mail.FromName,
+		FromAddr: mail.FromAddr,
+		Date:     time.UnixMilli(mail.Date).UTC().Format("2006-01-02 15:04"),
+		Subject:  mail.Subject,
+		Body:     mail.Body,
+		Seen:     mail.Seen,
+	}
+}
+
+// /mail lists the local mailbox, /mail?id=<id> opens a message and marks it read
+func HandleMail(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	id, _ := strconv.Atoi(r.FormValue("id"))
+
+	if r.Method == http.MethodPost && r.FormValue("action") == "delete" {
+		global.DeleteMail(acc.UserId, id)
+		message = "The message has been deleted."
+		id = 0
+	}
+
+	if id != 0 {
+		mail, ok := global.GetMail(acc.UserId, id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			renderPage(w, `<p>This message does not exist.</p>`, page{Title: "Mail", Token: token, User: acc})
+			return
+		}
+		global.MarkMailRead(acc.UserId, id)
+
+		renderPage(w, `{{with .Data}}<p><b>{{.Subject}}</b></p>
+<p>From {{.From}} &lt;{{.FromAddr}}&gt; ({{.Date}})</p>
+<pre>{{.Body}}</pre>
+<form method="post" action="/mail">
+<input type="hidden" name="token" value="{{$.Token}}"/>
+<input type="hidden" name="id" value="{{.Id}}"/>
+<input type="hidden" name="action" value="delete"/>
+<p><a href="/mail?token={{$.Token}}">Back</a> <input type="submit" value="Delete"/></p>
+</form>{{end}}`, page{Title: "Mail", Token: token, User: acc, Data: newMailEntry(mail)})
+		return
+	}
+
+	var entries []mailEntry
+	mails := global.GetMailbox(acc.UserId, mailboxSize)
+	for i := 0; i < len(mails); i++ {
+		entries = append(entries, newMailEntry(mails[i]))
+	}
+
+	renderPage(w, `{{if .Data}}<table>
+<tr><th>From</th><th>Subject</th><th>Date</th></tr>
+{{range .Data}}<tr><td>{{.From}}</td><td><a href="/mail?id={{.Id}}&token={{$.Token}}">{{if .Seen}}{{.Subject}}{{else}}<b>{{.Subject}}</b>{{end}}</a></td><td>{{.Date}}</td></tr>
+{{end}}</table>{{else}}<p>Your mailbox is empty.</p>{{end}}`, page{Title: "Mail", Token: token, User: acc, Message: message, Data: entries})
+}
+
+// POST /admin/mail with key, to, fromname, from, subject and body
+func HandleAdminMail(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminKey(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	to, err := strconv.Atoi(r.FormValue("to"))
+	if acc, ok := global.GetUserDataFromUserId(to); err != nil || !ok || acc.UserId == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown user\n"))
+		return
+	}
+
+	mail, ok := global.DeliverMail(to, r.FormValue("fromname"), r.FormValue("from"), r.FormValue("subject"), r.FormValue("body"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("invalid mail, from is required and subject can be up to %d characters long\n", global.MailMaxSubject)))
+		return
+	}
+
+	util.Log("WebAPI -> HandleAdminMail", "Delivered mail %d to user %d", mail.Id, to)
+	w.Write([]byte(strconv.Itoa(mail.Id) + "\n"))
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"phantom/global"
+	"phantom/util"
+)
+
+func HandleNowPlaying(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		if global.SetNowPlaying(acc.UserId, r.FormValue("band"), r.FormValue("song")) {
+			message = "Your now playing has been updated."
+		} else {
+			message = fmt.Sprintf("Band and song can be up to %d characters long.", global.NowPlayingMaxLength)
+		}
+	}
+
+	prf, _ := getProfile(acc.UserId)
+	renderPage(w, `{{with .Data}}<form method="post" action="/nowplaying">
+<input type="hidden" name="token" value="{{$.Token}}"/>
+<p>Band <input name="band" value="{{.BandName}}"/></p>
+<p>Song <input name="song" value="{{.SongName}}"/></p>
+<p><input type="submit" value="Update"/></p>
+</form>{{end}}`, page{Title: "Now Playing", Token: token, User: acc, Message: message, Data: prf})
+}
+
+// POST /local/nowplaying with key, user, band and song
+// meant for media player scripts on the server itself, so only loopback requests holding the admin key are accepted
+func HandleLocalNowPlaying(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !checkAdminKey(r) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	acc, ok := global.GetUserDataFromUsername(r.FormValue("user"))
+	if !ok || acc.UserId == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("unknown user\n"))
+		return
+	}
+
+	if !global.SetNowPlaying(acc.UserId, r.FormValue("band"), r.FormValue("song")) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid band or song\n"))
+		return
+	}
+
+	util.Debug("WebAPI -> HandleLocalNowPlaying", "%s is now playing %s - %s", acc.Username, r.FormValue("band"), r.FormValue("song"))
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"html/template"
+	"net/http"
+	"phantom/global"
+	"phantom/util"
+)
+
+var pages = template.Must(template.New("layout").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Title}} - Phantom IM</title></head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Token}}<p><a href="/home?token={{.Token}}">Home</a> | <a href="/profile/{{.User.UserId}}?token={{.Token}}">Profile</a> | <a href="/profile/edit?token={{.Token}}">Edit Profile</a>{{if .MSIM}} | <a href="/avatar?token={{.Token}}">Picture</a> | <a href="/inbox?token={{.Token}}">Inbox</a> | <a href="/bulletins?token={{.Token}}">Bulletins</a> | <a href="/nowplaying?token={{.Token}}">Now Playing</a>{{end}}{{if .MSNP}} | <a href="/mail?token={{.Token}}">Mail</a>{{end}} | <a href="/directory?token={{.Token}}">Directory</a></p>{{end}}
+{{if .Message}}<p><b>{{.Message}}</b></p>{{end}}
+{{template "content" .}}
+</body>
+</html>`))
+
+type page struct {
+	Title   string
+	Token   string
+	User    global.Account
+	Message string
+	Data    interface{}
+
+	// the nav only links pages whose service is enabled, see RunWebServer
+	MSIM bool
+	MSNP bool
+}
+
+func renderPage(w http.ResponseWriter, content string, p page) {
+	p.MSIM = util.GetServiceEnabled("msim")
+	p.MSNP = util.GetServiceEnabled("msnp")
+
+	t, err := template.Must(pages.Clone()).Parse(`{{define "content"}}` + content + `{{end}}`)
+	if err != nil {
+		util.Error("WebAPI -> renderPage", "Failed to parse page %s: %s", p.Title, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, p); err != nil {
+		util.Error("WebAPI -> renderPage", "Failed to render page %s: %s", p.Title, err.Error())
+	}
+}
+
+// pages opened from the clients carry a web token instead of a login
+func getWebUser(w http.ResponseWriter, r *http.Request) (global.Account, string, bool) {
+	token := r.FormValue("token")
+
+	uid, ok := global.LookupToken("web", token)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		renderPage(w, `<p>Your session has expired, please open this page from your messenger again.</p>`, page{Title: "Session Expired"})
+		return global.Account{}, "", false
+	}
+
+	acc, ok := global.GetUserDataFromUserId(uid)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return acc, "", false
+	}
+
+	return acc, token, true
+}
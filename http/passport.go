@@ -0,0 +1,142 @@
+package http
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	local stand-in for the passport nexus used by MSNP8+ (TWN) clients
+
+	/rdr/pprdr.asp -> PassportURLs header pointing DALogin at us
+	/login2.srf    -> checks the Passport1.4 credentials and hands out a "passport" token as ticket
+*/
+
+const (
+	passportTicketTTL     = 10 * time.Minute
+	passportFailureLimit  = 5
+	passportFailureWindow = 5 * time.Minute
+)
+
+// remote address or account -> times of their recent failed logins
+var passportFailures = map[string][]time.Time{}
+var passportLock sync.Mutex
+
+// drops failures older than passportFailureWindow, passportLock has to be held
+func recentPassportFailures(key string) []time.Time {
+	var recent []time.Time
+	for _, at := range passportFailures[key] {
+		if time.Since(at) < passportFailureWindow {
+			recent = append(recent, at)
+		}
+	}
+
+	if len(recent) == 0 {
+		delete(passportFailures, key)
+	} else {
+		passportFailures[key] = recent
+	}
+	return recent
+}
+
+// allows passportFailureLimit failed logins per address and per account within passportFailureWindow
+func allowPassportLogin(keys ...string) bool {
+	passportLock.Lock()
+	defer passportLock.Unlock()
+
+	for i := 0; i < len(keys); i++ {
+		if len(recentPassportFailures(keys[i])) >= passportFailureLimit {
+			return false
+		}
+	}
+	return true
+}
+
+func recordPassportFailure(keys ...string) {
+	passportLock.Lock()
+	defer passportLock.Unlock()
+
+	for i := 0; i < len(keys); i++ {
+		passportFailures[keys[i]] = append(recentPassportFailures(keys[i]), time.Now())
+	}
+}
+
+func HandleNexus(w http.ResponseWriter, r *http.Request) {
+	login := util.GetRootUrl() + "/login2.srf"
+
+	w.Header().Set("PassportURLs", fmt.Sprintf("DARealm=Passport.Net,DALogin=%s,DAReg=%s/register,Properties=%s/profile,Privacy=%s/privacy,GeneralRedir=%s/home,Help=%s/help,ConfigVersion=15", login, util.GetRootUrl(), util.GetRootUrl(), util.GetRootUrl(), util.GetRootUrl(), util.GetRootUrl()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// Authorization: Passport1.4 OrgVerb=GET,OrgURL=...,sign-in=<email>,pwd=<password>,...
+func parsePassportAuthorization(header string) map[string]string {
+	fields := map[string]string{}
+
+	if !strings.HasPrefix(header, "Passport1.4 ") {
+		return fields
+	}
+
+	pairs := strings.Split(strings.TrimPrefix(header, "Passport1.4 "), ",")
+	for i := 0; i < len(pairs); i++ {
+		sep := strings.Index(pairs[i], "=")
+		if sep < 0 {
+			continue
+		}
+
+		value, err := url.QueryUnescape(pairs[i][sep+1:])
+		if err != nil {
+			value = pairs[i][sep+1:]
+		}
+		fields[strings.TrimSpace(pairs[i][:sep])] = value
+	}
+
+	return fields
+}
+
+func HandlePassportLogin(w http.ResponseWriter, r *http.Request) {
+	fields := parsePassportAuthorization(r.Header.Get("Authorization"))
+
+	email := strings.Replace(fields["sign-in"], "@hotmail.com", util.GetMailDomain(), -1)
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	keys := []string{"addr:" + host, "account:" + strings.ToLower(email)}
+
+	if !allowPassportLogin(keys...) {
+		util.Log("WebAPI -> HandlePassportLogin", "Too many failed passport logins for %s from %s", email, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Passport1.4 da-status=failed,srealm=Passport.NET,ts=-3,prompt,cburl=,cbtxt=")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	acc, _ := global.GetUserDataFromEmail(email)
+
+	password := ""
+	if acc.UserId != 0 {
+		password = strings.Replace(util.DecryptAES(util.GetAESKey(), acc.Password), "\r\n", "", -1)
+	}
+
+	if acc.UserId == 0 || fields["pwd"] == "" || subtle.ConstantTimeCompare([]byte(fields["pwd"]), []byte(password)) != 1 {
+		recordPassportFailure(keys...)
+		util.Log("WebAPI -> HandlePassportLogin", "Failed passport login for %s from %s", email, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Passport1.4 da-status=failed,srealm=Passport.NET,ts=-3,prompt,cburl=,cbtxt=")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ticket := global.IssueToken("passport", acc.UserId, passportTicketTTL)
+
+	util.Log("WebAPI -> HandlePassportLogin", "Issued passport ticket for %s", acc.Email)
+	w.Header().Set("Authentication-Info", fmt.Sprintf("Passport1.4 da-status=success,tname=MSPAuth,tname=MSPProf,tname=MSPSec,from-PP='t=%s&p=',ru=http://messenger.msn.com", ticket))
+	w.WriteHeader(http.StatusOK)
+}
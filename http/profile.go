@@ -0,0 +1,195 @@
+package http
+
+import (
+	"net/http"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type profile struct {
+	Account    global.Account
+	AvatarType string
+	BandName   string
+	SongName   string
+	Age        int
+	Gender     string
+	Location   string
+	Headline   string
+}
+
+func getProfile(uid int) (profile, bool) {
+	var prf profile
+
+	acc, ok := global.GetUserDataFromUserId(uid)
+	if !ok || acc.UserId == 0 {
+		return prf, false
+	}
+	prf.Account = acc
+
+	row, err := util.GetDatabaseHandle().Query("SELECT avatartype, bandname, songname, age, gender, location, headline from myspace WHERE id= ?", uid)
+	if err != nil {
+		util.Error("WebAPI -> getProfile", err.Error())
+		return prf, false
+	}
+
+	if row.Next() {
+		row.Scan(&prf.AvatarType, &prf.BandName, &prf.SongName, &prf.Age, &prf.Gender, &prf.Location, &prf.Headline)
+	}
+	row.Close()
+
+	return prf, true
+}
+
+func HandleHome(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	renderPage(w, `<p>Welcome back, {{.User.Screenname}}!</p>`, page{Title: "Home", Token: token, User: acc})
+}
+
+// /profile/<id>
+func HandleProfile(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/profile/"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		renderPage(w, `<p>This profile does not exist.</p>`, page{Title: "Profile", Token: token, User: acc})
+		return
+	}
+
+	prf, ok := getProfile(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		renderPage(w, `<p>This profile does not exist.</p>`, page{Title: "Profile", Token: token, User: acc})
+		return
+	}
+
+	prv, _ := global.GetPrivacyFromUserId(id)
+	if prv.PrivacyMode != 0 && id != acc.UserId && !global.IsContact(id, acc.UserId) {
+		renderPage(w, `<p>This profile is only visible to friends.</p>`, page{Title: prf.Account.Screenname, Token: token, User: acc})
+		return
+	}
+
+	renderPage(w, `{{with .Data}}
+{{if .AvatarType}}<img src="/pfp/id={{.Account.UserId}}.{{.AvatarType}}" alt="picture"/>{{end}}
+<p><i>{{.Headline}}</i></p>
+<table>
+<tr><td>Age</td><td>{{if .Age}}{{.Age}}{{end}}</td></tr>
+<tr><td>Gender</td><td>{{.Gender}}</td></tr>
+<tr><td>Location</td><td>{{.Location}}</td></tr>
+<tr><td>Now Playing</td><td>{{.BandName}} {{if .SongName}}- {{.SongName}}{{end}}</td></tr>
+</table>
+{{if ne .Account.UserId $.User.UserId}}<p><a href="/addfriend/{{.Account.UserId}}?token={{$.Token}}">Add to Friends</a></p>{{end}}
+{{end}}`, page{Title: prf.Account.Screenname, Token: token, User: acc, Data: prf})
+}
+
+func HandleProfileEdit(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	message := ""
+	if r.Method == http.MethodPost {
+		age, _ := strconv.Atoi(r.FormValue("age"))
+		res, err := util.GetDatabaseHandle().Query("UPDATE myspace SET headline= ?, age= ?, gender= ?, location= ? WHERE id= ?", r.FormValue("headline"), age, r.FormValue("gender"), r.FormValue("location"), acc.UserId)
+		if err != nil {
+			util.Error("WebAPI -> HandleProfileEdit", err.Error())
+			message = "Your profile could not be saved."
+		} else {
+			res.Close()
+			message = "Your profile has been saved."
+		}
+	}
+
+	prf, _ := getProfile(acc.UserId)
+	renderPage(w, `{{with .Data}}<form method="post" action="/profile/edit">
+<input type="hidden" name="token" value="{{$.Token}}"/>
+<p>Headline <input name="headline" value="{{.Headline}}"/></p>
+<p>Age <input name="age" value="{{.Age}}"/></p>
+<p>Gender <input name="gender" value="{{.Gender}}"/></p>
+<p>Location <input name="location" value="{{.Location}}"/></p>
+<p><input type="submit" value="Save"/></p>
+</form>{{end}}`, page{Title: "Edit Profile", Token: token, User: acc, Message: message, Data: prf})
+}
+
+// /addfriend/<id>
+func HandleAddFriend(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/addfriend/"))
+	prf, ok := getProfile(id)
+	if !ok || id == acc.UserId {
+		w.WriteHeader(http.StatusNotFound)
+		renderPage(w, `<p>This user can not be added.</p>`, page{Title: "Add Friend", Token: token, User: acc})
+		return
+	}
+
+	message := ""
+	if global.IsContact(acc.UserId, id) {
+		message = prf.Account.Screenname + " is already your friend."
+	} else if r.Method == http.MethodPost {
+		res, err := util.GetDatabaseHandle().Query("INSERT into contacts (`from_id`, `to_id`) VALUES (?, ?)", acc.UserId, id)
+		if err != nil {
+			util.Error("WebAPI -> HandleAddFriend", err.Error())
+			message = "The friend could not be added."
+		} else {
+			res.Close()
+			global.IncrementListVersion(acc.UserId)
+			global.IncrementListVersion(id)
+			message = prf.Account.Screenname + " has been added to your friends."
+		}
+	}
+
+	renderPage(w, `{{if not .Message}}<form method="post" action="/addfriend/{{.Data.Account.UserId}}">
+<input type="hidden" name="token" value="{{.Token}}"/>
+<p>Add {{.Data.Account.Screenname}} to your friends?</p>
+<p><input type="submit" value="Add"/></p>
+</form>{{end}}`, page{Title: "Add Friend", Token: token, User: acc, Message: message, Data: prf})
+}
+
+type inboxEntry struct {
+	From    string
+	Date    string
+	Message string
+}
+
+// pending offline messages, they are still delivered on the next login
+func HandleInbox(w http.ResponseWriter, r *http.Request) {
+	acc, token, ok := getWebUser(w, r)
+	if !ok {
+		return
+	}
+
+	var entries []inboxEntry
+	res, err := util.GetDatabaseHandle().Query("SELECT a.screenname, o.date, o.message from offlinemsgs o INNER JOIN accounts a ON a.id = o.from_id WHERE o.to_id= ? ORDER BY o.date", acc.UserId)
+	if err != nil {
+		util.Error("WebAPI -> HandleInbox", err.Error())
+	} else {
+		for res.Next() {
+			var entry inboxEntry
+			var date int64
+			res.Scan(&entry.From, &date, &entry.Message)
+			entry.Date = time.UnixMilli(date).UTC().Format("2006-01-02 15:04")
+			entries = append(entries, entry)
+		}
+		res.Close()
+	}
+
+	renderPage(w, `{{if .Data}}<table>
+<tr><th>From</th><th>Date</th><th>Message</th></tr>
+{{range .Data}}<tr><td>{{.From}}</td><td>{{.Date}}</td><td>{{.Message}}</td></tr>
+{{end}}</table>{{else}}<p>You have no new messages.</p>{{end}}`, page{Title: "Inbox", Token: token, User: acc, Data: entries})
+}
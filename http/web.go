@@ -7,6 +7,17 @@ import (
 )
 
 func RunWebServer(port int) {
+	// account pages are linked from both clients, msim through netlinks and msnp through URL
+	if util.GetServiceEnabled("msim") || util.GetServiceEnabled("msnp") {
+		util.Log("WebAPI Handler", "Installed Account Pages")
+		http.HandleFunc("/home", HandleHome)
+		http.HandleFunc("/profile/edit", HandleProfileEdit)
+		http.HandleFunc("/profile/", HandleProfile)
+
+		util.Log("WebAPI Handler", "Installed Directory Listing Page")
+		http.HandleFunc("/directory", HandleDirectory)
+	}
+
 	if util.GetServiceEnabled("msim") {
 		util.Log("WebAPI Handler", "Installed IM Picture Handler for MSIM")
 		http.HandleFunc("/pfp/", HandlePFP)
@@ -14,6 +25,32 @@ func RunWebServer(port int) {
 		util.Log("WebAPI Handler", "Installed Advertisment Handler for MSIM")
 		http.HandleFunc("/html.ng/", CycleMySpaceAds)
 		http.HandleFunc("/adopt/", CycleMySpaceAds)
+
+		util.Log("WebAPI Handler", "Installed NetLink Pages for MSIM")
+		http.HandleFunc("/addfriend/", HandleAddFriend)
+		http.HandleFunc("/avatar", HandleAvatarEditor)
+		http.HandleFunc("/inbox", HandleInbox)
+		http.HandleFunc("/bulletins", HandleBulletins)
+		http.HandleFunc("/nowplaying", HandleNowPlaying)
+
+		util.Log("WebAPI Handler", "Installed local Now Playing hook for MSIM")
+		http.HandleFunc("/local/nowplaying", HandleLocalNowPlaying)
+
+		util.Log("WebAPI Handler", "Installed Admin API for MSIM")
+		http.HandleFunc("/admin/bulletin", HandleAdminBulletin)
+	}
+
+	if util.GetServiceEnabled("msnp") {
+		util.Log("WebAPI Handler", "Installed Passport Handler for MSNP")
+		http.HandleFunc("/rdr/pprdr.asp", HandleNexus)
+		http.HandleFunc("/login2.srf", HandlePassportLogin)
+
+		util.Log("WebAPI Handler", "Installed HTTP Gateway for MSNP")
+		http.HandleFunc("/gateway/gateway.dll", HandleGateway)
+
+		util.Log("WebAPI Handler", "Installed Mailbox for MSNP")
+		http.HandleFunc("/mail", HandleMail)
+		http.HandleFunc("/admin/mail", HandleAdminMail)
 	}
 
 	if util.GetServiceEnabled("ypager") {
@@ -21,6 +58,17 @@ func RunWebServer(port int) {
 		http.HandleFunc("/config/", HandleYPager)
 	}
 
+	// passport clients insist on https, a certificate for the redirected hosts can be configured
+	if cert, key, tlsport := util.GetTLSConfig(); cert != "" && key != "" {
+		go func() {
+			util.Log("HTTPS Listener", "Listening on 0.0.0.0:%d", tlsport)
+			err := http.ListenAndServeTLS(":"+strconv.Itoa(tlsport), cert, key, nil)
+			if err != nil {
+				util.Error("WebAPI -> RunWebServer", "Error setting up https server: %s", err.Error())
+			}
+		}()
+	}
+
 	util.Log("HTTP Listener", "Listening on 0.0.0.0:%d", port)
 	err := http.ListenAndServe(":"+strconv.Itoa(port), nil)
 	if err != nil {
@@ -60,6 +60,11 @@ func main() {
 		go port1863Handler()
 	}
 
+	if util.GetServiceEnabled("msim") {
+		util.Log("Handler", "Launched Offline Message Expiry for MSIM")
+		go msim.HandleOfflineExpiry()
+	}
+
 	if util.GetServiceEnabled("msnp") {
 
 		util.Log("Handler", "Launched Handler for MSNP Switchboard")
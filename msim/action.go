@@ -0,0 +1,171 @@
+package msim
+
+import (
+	"fmt"
+	"html"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	bm 1   -> im or action, stored offline
+	bm 121 -> im or action, dropped when the buddy is offline
+
+	action messages:
+	%typing%                         -> typing
+	%stoptyping%                     -> stopped typing
+	!!!ZAP_SEND!!!=RTE_BTN_ZAPS_<n>  -> zap
+	!!!<anything else>               -> unknown action
+*/
+
+const (
+	msim_bm_action_or_im_delayable = 1
+	msim_bm_action_or_im_instant   = 121
+)
+
+const msim_zap_interval = 10 * time.Second
+
+var msim_zap_names = []string{"Zap", "Whack", "Torch", "Smooch", "Hug", "BSlap", "Goose", "Hi-five", "Punk'd", "Raspberry"}
+
+func init() {
+	global.RegisterActionTranslator("MySpaceIM", handleTranslateAction)
+}
+
+// events from other protocols become bm packets, text is escaped since msim renders markup
+func handleTranslateAction(target *global.Client, event global.ActionEvent) bool {
+	ctx := getUserContext(target.Account.UserId)
+	if ctx == nil {
+		return false
+	}
+
+	bm := msim_bm_action_or_im_instant
+	msg := ""
+
+	switch event.Kind {
+	case global.ActionText:
+		bm = msim_bm_action_or_im_delayable
+		msg = html.EscapeString(event.Text)
+	case global.ActionTyping:
+		msg = "%typing%"
+	case global.ActionStopTyping:
+		msg = "%stoptyping%"
+	case global.ActionZap:
+		bm = msim_bm_action_or_im_delayable
+		msg = html.EscapeString(event.Text)
+		if ctx.supports(msim_feature_zaps) {
+			msg = fmt.Sprintf("!!!ZAP_SEND!!!=RTE_BTN_ZAPS_%d", event.Zap)
+		}
+	default:
+		return false
+	}
+
+	return util.WriteTraffic(ctx.client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_int("bm", bm),
+		msim_new_data_int("sesskey", ctx.sesskey),
+		msim_new_data_int("f", event.From.UserId),
+		msim_new_data_string("msg", msg),
+	})) == nil
+}
+
+func parseActionMessage(msg string) global.ActionEvent {
+	event := global.ActionEvent{Kind: global.ActionText, Text: msg}
+
+	switch {
+	case msg == "%typing%":
+		event.Kind = global.ActionTyping
+	case msg == "%stoptyping%":
+		event.Kind = global.ActionStopTyping
+	case strings.HasPrefix(msg, "!!!ZAP_SEND!!!=RTE_BTN_ZAPS_"):
+		zap, err := strconv.Atoi(strings.TrimPrefix(msg, "!!!ZAP_SEND!!!=RTE_BTN_ZAPS_"))
+		if err == nil && zap >= 0 && zap < len(msim_zap_names) {
+			event.Kind = global.ActionZap
+			event.Zap = zap
+		} else {
+			event.Kind = global.ActionUnknown
+		}
+	case strings.HasPrefix(msg, "!!!"):
+		event.Kind = global.ActionUnknown
+	}
+
+	return event
+}
+
+func getZapName(zap int) string {
+	if zap < 0 || zap >= len(msim_zap_names) {
+		return "Zap"
+	}
+	return msim_zap_names[zap]
+}
+
+// one zap per buddy every msim_zap_interval
+func (ctx *msim_context) allowZap(to int) bool {
+	if ctx.lastzap == nil {
+		ctx.lastzap = map[int]time.Time{}
+	}
+
+	if last, ok := ctx.lastzap[to]; ok && time.Since(last) < msim_zap_interval {
+		return false
+	}
+
+	ctx.lastzap[to] = time.Now()
+	return true
+}
+
+func storeActionHistory(event global.ActionEvent) {
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO actions (`from_id`, `to_id`, `type`, `zap`, `date`) VALUES (?, ?, ?, ?, ?)", event.From.UserId, event.ToId, event.Kind, event.Zap, time.Now().UTC().UnixMilli())
+	if err != nil {
+		util.Error("MySpace -> storeActionHistory", err.Error())
+		return
+	}
+	res.Close()
+}
+
+// bm type 1, 121
+func handleClientPacketBuddyInstantMessage(client *global.Client, ctx *msim_context, packet *msim_message) {
+	bm := packet.getInt("bm")
+	t := packet.getInt("t")
+	msg := packet.get("msg")
+	date := time.Now().UTC().UnixMilli()
+
+	event := parseActionMessage(msg)
+	event.From = client.Account
+	event.ToId = t
+
+	if event.Kind == global.ActionZap {
+		if !ctx.allowZap(t) {
+			util.Debug("MySpace -> handleClientPacketBuddyInstantMessage", "Dropping zap from %s, sent too fast", client.Account.Username)
+			return
+		}
+		storeActionHistory(event)
+
+		// other protocols and builds without zaps get a readable message instead
+		event.Text = fmt.Sprintf("%s sent you a %s!", client.Account.Screenname, getZapName(event.Zap))
+	}
+
+	if target := getUserContext(t); target != nil {
+		if event.Kind == global.ActionZap && !target.supports(msim_feature_zaps) {
+			msg = event.Text
+		}
+
+		util.WriteTraffic(target.client.Connection, buildDataPacket([]msim_data_pair{
+			msim_new_data_int("bm", bm),
+			msim_new_data_int("sesskey", target.sesskey),
+			msim_new_data_int("f", client.Account.UserId),
+			msim_new_data_string("msg", msg),
+		}))
+		return
+	}
+
+	if other := global.GetClientByUserId(t); other != nil && global.DeliverAction(other, event) {
+		return
+	}
+
+	if bm == msim_bm_action_or_im_instant || event.Kind == global.ActionTyping || event.Kind == global.ActionStopTyping {
+		return
+	}
+
+	storeOfflineMessage(client, t, msg, date)
+}
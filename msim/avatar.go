@@ -0,0 +1,109 @@
+package msim
+
+import (
+	"encoding/base64"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const msim_avatar_timeout = 60 * time.Second
+
+// discards the upload if the client stopped sending chunks
+func (upload *msim_avatar_upload) expired() bool {
+	return time.Since(upload.lastchunk) > msim_avatar_timeout
+}
+
+// persist 514;8;13 2;8;13 change_profile_picture
+func handleClientPacketChangePicture(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	if len(req.body) == 0 {
+		handleClientPersistError(client, req, "Missing picture data.")
+		return
+	}
+
+	if ctx.upload != nil && ctx.upload.expired() {
+		util.Debug("MySpace -> handleClientPacketChangePicture", "Discarding stale upload of %s", client.Account.Username)
+		ctx.upload = nil
+	}
+
+	if ctx.upload == nil {
+		ctx.upload = &msim_avatar_upload{}
+	}
+
+	// chunk data is the last entry unless the client names it
+	data := req.body.get("Data")
+	if !req.body.has("Data") {
+		data = req.body[len(req.body)-1].Value
+	}
+
+	last := strings.EqualFold(req.body.get("LastChunk"), "True")
+
+	if !req.body.has("ChunkNumber") {
+		ctx.upload = nil
+		handleClientPersistError(client, req, "Picture chunk is missing its number.")
+		return
+	}
+
+	number, err := strconv.Atoi(req.body.get("ChunkNumber"))
+	if err != nil || number != ctx.upload.chunks {
+		util.Debug("MySpace -> handleClientPacketChangePicture", "Out of order chunk %q from %s, expected %d", req.body.get("ChunkNumber"), client.Account.Username, ctx.upload.chunks)
+		ctx.upload = nil
+		handleClientPersistError(client, req, "Picture chunks arrived out of order.")
+		return
+	}
+
+	part, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		ctx.upload = nil
+		handleClientPersistError(client, req, "Invalid picture data.")
+		return
+	}
+
+	if len(ctx.upload.data)+len(part) > util.AvatarMaxSize {
+		ctx.upload = nil
+		handleClientPersistError(client, req, "The picture is too large.")
+		return
+	}
+
+	ctx.upload.data = append(ctx.upload.data, part...)
+	ctx.upload.chunks++
+	ctx.upload.lastchunk = time.Now()
+
+	if last {
+		image := ctx.upload.data
+		ctx.upload = nil
+
+		pfpType, width, height, err := util.DetectImage(image)
+		if err != nil {
+			util.Debug("MySpace -> handleClientPacketChangePicture", "Rejected picture of %s: %s", client.Account.Username, err.Error())
+			handleClientPersistError(client, req, "The picture is not a valid GIF, PNG or JPEG image.")
+			return
+		}
+
+		if width > util.AvatarMaxDimension || height > util.AvatarMaxDimension {
+			handleClientPersistError(client, req, "The picture dimensions are too large.")
+			return
+		}
+
+		res, err := util.GetDatabaseHandle().Query("UPDATE upload SET avatar= ? WHERE id= ?", base64.StdEncoding.EncodeToString(image), client.Account.UserId)
+		if err != nil {
+			util.Error("MySpace -> handleClientPacketChangePicture", err.Error())
+			handleClientPersistError(client, req, "The picture could not be saved.")
+			return
+		}
+		res.Close()
+		res, err = util.GetDatabaseHandle().Query("UPDATE myspace SET avatartype= ? WHERE id= ?", pfpType, client.Account.UserId)
+		if err != nil {
+			util.Error("MySpace -> handleClientPacketChangePicture", err.Error())
+			handleClientPersistError(client, req, "The picture could not be saved.")
+			return
+		}
+		res.Close()
+
+		util.Debug("MySpace -> handleClientPacketChangePicture", "Stored %dx%d %s picture for %s", width, height, pfpType, client.Account.Username)
+	}
+
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, ""))
+}
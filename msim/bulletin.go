@@ -0,0 +1,74 @@
+package msim
+
+import (
+	"fmt"
+	"phantom/global"
+	"phantom/util"
+)
+
+func init() {
+	global.RegisterBulletinNotifier("MySpaceIM", notifyBulletin)
+}
+
+func buildNotificationBody(uid int) string {
+	count := global.CountUnreadBulletins(uid)
+
+	state := "Off"
+	if count > 0 {
+		state = "On"
+	}
+
+	return buildDataBody([]msim_data_pair{
+		msim_new_data_string("Bulletin", state),
+		msim_new_data_int("BulletinCount", count),
+	})
+}
+
+// newer builds refresh their notification pane, older ones get the subject as a plain message
+func sendBulletinNotification(ctx *msim_context, bulletin global.Bulletin) bool {
+	if ctx.supports(msim_feature_notifications) {
+		req := &msim_persist_request{cmd: msim_cmd_get, dsn: 7, lid: 18}
+		return util.WriteTraffic(ctx.client.Connection, buildPersistReply(ctx.client, req, buildNotificationBody(ctx.client.Account.UserId))) == nil
+	}
+
+	return util.WriteTraffic(ctx.client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_int("bm", msim_bm_action_or_im_instant),
+		msim_new_data_int("sesskey", ctx.sesskey),
+		msim_new_data_int("f", bulletin.FromId),
+		msim_new_data_string("msg", fmt.Sprintf("New bulletin: %s", bulletin.Subject)),
+	})) == nil
+}
+
+func notifyBulletin(target *global.Client, bulletin global.Bulletin) bool {
+	ctx := getUserContext(target.Account.UserId)
+	if ctx == nil {
+		return false
+	}
+	return sendBulletinNotification(ctx, bulletin)
+}
+
+// bulletins posted while this user was offline
+func handleClientHandleBulletins(client *global.Client, ctx *msim_context) {
+	bulletins := global.GetUnnotifiedBulletins(client.Account.UserId)
+	if len(bulletins) == 0 {
+		return
+	}
+
+	// one notification covers the whole pane, older builds get a message per bulletin
+	if ctx.supports(msim_feature_notifications) {
+		bulletins = bulletins[len(bulletins)-1:]
+	}
+
+	for i := 0; i < len(bulletins); i++ {
+		if !sendBulletinNotification(ctx, bulletins[i]) {
+			return
+		}
+	}
+
+	global.MarkBulletinsNotified(client.Account.UserId)
+}
+
+// persist 1;7;18
+func handleClientPacketNewNotificationRequest(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildNotificationBody(client.Account.UserId)))
+}
@@ -0,0 +1,262 @@
+package msim
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+/*
+	msim packets are ordered key/value pairs: \key\value\key\value\final\
+
+	values are escaped once, at the leaf:
+	/1 -> /
+	/2 -> \
+
+	dictionaries are key=value entries separated by \x1c, lists are items
+	separated by |. both are stored raw in the packet so they aren't escaped twice.
+	clients only know /1 and /2, so a delimiter inside a leaf that would split it
+	(= and \x1c in dictionary keys, \x1c in dictionary values, | in list items)
+	is replaced with a space. = in dictionary values is fine, only the first one splits.
+*/
+
+const (
+	msim_type_string = iota
+	msim_type_boolean
+	msim_type_dictionary
+	msim_type_list
+)
+
+const msim_packet_terminator = "\\final\\"
+
+var msim_escape_codes = map[byte]string{
+	'/':  "/1",
+	'\\': "/2",
+}
+
+var msim_unescape_codes = map[byte]byte{
+	'1': '/',
+	'2': '\\',
+}
+
+const (
+	msim_escape_value     = "/\\"
+	msim_escape_dict_key  = "/\\=\x1c"
+	msim_escape_dict_item = "/\\\x1c"
+	msim_escape_list_item = "/\\|"
+)
+
+type msim_message struct {
+	pairs []msim_data_pair
+}
+
+func escapeValue(data string, special string) string {
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		if strings.IndexByte(special, data[i]) < 0 {
+			sb.WriteByte(data[i])
+		} else if code, ok := msim_escape_codes[data[i]]; ok {
+			sb.WriteString(code)
+		} else {
+			sb.WriteByte(' ')
+		}
+	}
+	return sb.String()
+}
+
+func unescapeValue(data string) string {
+	if !strings.Contains(data, "/") {
+		return data
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '/' && i+1 < len(data) {
+			if c, ok := msim_unescape_codes[data[i+1]]; ok {
+				sb.WriteByte(c)
+				i++
+				continue
+			}
+		}
+		sb.WriteByte(data[i])
+	}
+	return sb.String()
+}
+
+func buildDataPacket(datapairs []msim_data_pair) string {
+	var sb strings.Builder
+	for i := 0; i < len(datapairs); i++ {
+		switch datapairs[i].Type {
+		case msim_type_boolean:
+			if datapairs[i].Value == "" {
+				continue
+			}
+			sb.WriteString("\\" + datapairs[i].Key + "\\" + datapairs[i].Value)
+		case msim_type_dictionary, msim_type_list:
+			sb.WriteString("\\" + datapairs[i].Key + "\\" + datapairs[i].Value)
+		default:
+			sb.WriteString("\\" + datapairs[i].Key + "\\" + escapeValue(datapairs[i].Value, msim_escape_value))
+		}
+	}
+	sb.WriteString(msim_packet_terminator)
+	return sb.String()
+}
+
+func buildDataBody(datapairs []msim_data_pair) string {
+	var sb strings.Builder
+	for i := 0; i < len(datapairs); i++ {
+		sb.WriteString(escapeValue(datapairs[i].Key, msim_escape_dict_key))
+		sb.WriteString("=")
+		sb.WriteString(escapeValue(datapairs[i].Value, msim_escape_dict_item))
+		sb.WriteString("\x1c")
+	}
+	return sb.String()
+}
+
+func buildDataList(items []string) string {
+	escaped := make([]string, len(items))
+	for i := 0; i < len(items); i++ {
+		escaped[i] = escapeValue(items[i], msim_escape_list_item)
+	}
+	return strings.Join(escaped, "|")
+}
+
+func parseDataBody(body string) msim_dictionary {
+	var dict msim_dictionary
+
+	entries := strings.Split(body, "\x1c")
+	for i := 0; i < len(entries); i++ {
+		if entries[i] == "" {
+			continue
+		}
+		kv := strings.SplitN(entries[i], "=", 2)
+		if len(kv) == 2 {
+			dict = append(dict, msim_new_data_string(unescapeValue(kv[0]), unescapeValue(kv[1])))
+		} else {
+			dict = append(dict, msim_new_data_string(unescapeValue(kv[0]), ""))
+		}
+	}
+
+	return dict
+}
+
+func parseDataList(list string) []string {
+	if list == "" {
+		return nil
+	}
+
+	items := strings.Split(list, "|")
+	for i := 0; i < len(items); i++ {
+		items[i] = unescapeValue(items[i])
+	}
+	return items
+}
+
+// parseDataPacket decodes a single \...\final\ packet, values are kept raw until requested
+func parseDataPacket(packet []byte) (*msim_message, bool) {
+	str := string(bytes.Trim(packet, "\x00\r\n"))
+
+	if !strings.HasPrefix(str, "\\") || !strings.HasSuffix(str, msim_packet_terminator) {
+		return nil, false
+	}
+
+	str = strings.TrimSuffix(str[1:], msim_packet_terminator)
+	if str == "" {
+		return nil, false
+	}
+
+	tokens := strings.Split(str, "\\")
+	msg := msim_message{}
+	for i := 0; i < len(tokens); i += 2 {
+		pair := msim_data_pair{Key: tokens[i]}
+		if i+1 < len(tokens) {
+			pair.Value = tokens[i+1]
+		}
+		msg.pairs = append(msg.pairs, pair)
+	}
+
+	return &msg, true
+}
+
+// splitDataPackets cuts complete packets off the front of a stream buffer and returns the unfinished rest
+func splitDataPackets(stream []byte) ([][]byte, []byte) {
+	var packets [][]byte
+
+	for {
+		ix := bytes.Index(stream, []byte(msim_packet_terminator))
+		if ix < 0 {
+			break
+		}
+		end := ix + len(msim_packet_terminator)
+		packets = append(packets, stream[:end])
+		stream = stream[end:]
+	}
+
+	return packets, stream
+}
+
+func (msg *msim_message) command() string {
+	if len(msg.pairs) == 0 {
+		return ""
+	}
+	return msg.pairs[0].Key
+}
+
+func (msg *msim_message) raw(key string) (string, bool) {
+	for i := 0; i < len(msg.pairs); i++ {
+		if msg.pairs[i].Key == key {
+			return msg.pairs[i].Value, true
+		}
+	}
+	return "", false
+}
+
+func (msg *msim_message) has(key string) bool {
+	_, ok := msg.raw(key)
+	return ok
+}
+
+func (msg *msim_message) get(key string) string {
+	value, _ := msg.raw(key)
+	return unescapeValue(value)
+}
+
+func (msg *msim_message) getInt(key string) int {
+	value, _ := strconv.Atoi(msg.get(key))
+	return value
+}
+
+func (msg *msim_message) getDictionary(key string) msim_dictionary {
+	value, _ := msg.raw(key)
+	return parseDataBody(value)
+}
+
+func (msg *msim_message) getList(key string) []string {
+	value, _ := msg.raw(key)
+	return parseDataList(value)
+}
+
+func (dict msim_dictionary) get(key string) string {
+	for i := 0; i < len(dict); i++ {
+		if dict[i].Key == key {
+			return dict[i].Value
+		}
+	}
+	return ""
+}
+
+func (dict msim_dictionary) has(key string) bool {
+	for i := 0; i < len(dict); i++ {
+		if dict[i].Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (dict msim_dictionary) first() string {
+	if len(dict) == 0 {
+		return ""
+	}
+	return dict[0].Value
+}
@@ -0,0 +1,157 @@
+package msim
+
+import (
+	"reflect"
+	"testing"
+)
+
+// every character the codec treats specially, in one value
+const msim_test_special = "a/b\\c|d=e\x1cf/1g/2h"
+
+func TestPacketRoundTrip(t *testing.T) {
+	packet := buildDataPacket([]msim_data_pair{
+		msim_new_data_string("bm", "1"),
+		msim_new_data_int("sesskey", 12345),
+		msim_new_data_string("msg", msim_test_special),
+		msim_new_data_string("empty", ""),
+		msim_new_data_boolean("flag", true),
+		msim_new_data_boolean("skipped", false),
+	})
+
+	msg, ok := parseDataPacket([]byte(packet))
+	if !ok {
+		t.Fatalf("failed to parse %q", packet)
+	}
+
+	if msg.command() != "bm" {
+		t.Errorf("command = %q, want bm", msg.command())
+	}
+	if msg.getInt("sesskey") != 12345 {
+		t.Errorf("sesskey = %d, want 12345", msg.getInt("sesskey"))
+	}
+	if msg.get("msg") != msim_test_special {
+		t.Errorf("msg = %q, want %q", msg.get("msg"), msim_test_special)
+	}
+	if !msg.has("empty") || msg.get("empty") != "" {
+		t.Errorf("empty value was not kept")
+	}
+	if msg.get("flag") != "1" {
+		t.Errorf("flag = %q, want 1", msg.get("flag"))
+	}
+	if msg.has("skipped") {
+		t.Errorf("false boolean should be left out")
+	}
+}
+
+func TestDictionaryRoundTrip(t *testing.T) {
+	body := buildDataBody([]msim_data_pair{
+		msim_new_data_string("Path/Key\\", "x/y\\z|w=v"),
+		msim_new_data_string("Plain", "value"),
+		msim_new_data_string("Empty", ""),
+	})
+
+	packet := buildDataPacket([]msim_data_pair{
+		msim_new_data_string("persist", "1"),
+		msim_new_data_dictonary("body", body),
+	})
+
+	msg, ok := parseDataPacket([]byte(packet))
+	if !ok {
+		t.Fatalf("failed to parse %q", packet)
+	}
+
+	dict := msg.getDictionary("body")
+	want := msim_dictionary{
+		msim_new_data_string("Path/Key\\", "x/y\\z|w=v"),
+		msim_new_data_string("Plain", "value"),
+		msim_new_data_string("Empty", ""),
+	}
+	if !reflect.DeepEqual(dict, want) {
+		t.Errorf("dictionary = %q, want %q", dict, want)
+	}
+}
+
+func TestDictionaryDelimitersDoNotSplit(t *testing.T) {
+	body := buildDataBody([]msim_data_pair{
+		msim_new_data_string("A=B\x1cC", "1\x1c2"),
+		msim_new_data_string("Next", "ok"),
+	})
+
+	dict := parseDataBody(body)
+	want := msim_dictionary{
+		msim_new_data_string("A B C", "1 2"),
+		msim_new_data_string("Next", "ok"),
+	}
+	if !reflect.DeepEqual(dict, want) {
+		t.Errorf("dictionary = %q, want %q", dict, want)
+	}
+}
+
+func TestListRoundTrip(t *testing.T) {
+	items := []string{"s", "1", "ss", "a/b\\c=d\x1ce", ""}
+
+	packet := buildDataPacket([]msim_data_pair{
+		msim_new_data_string("status", "1"),
+		msim_new_data_list("statstring", items),
+	})
+
+	msg, ok := parseDataPacket([]byte(packet))
+	if !ok {
+		t.Fatalf("failed to parse %q", packet)
+	}
+
+	list := msg.getList("statstring")
+	if !reflect.DeepEqual(list, items) {
+		t.Errorf("list = %q, want %q", list, items)
+	}
+}
+
+func TestListDelimiterDoesNotSplit(t *testing.T) {
+	list := parseDataList(buildDataList([]string{"away|brb", "next"}))
+
+	want := []string{"away brb", "next"}
+	if !reflect.DeepEqual(list, want) {
+		t.Errorf("list = %q, want %q", list, want)
+	}
+}
+
+func TestSplitDataPackets(t *testing.T) {
+	stream := []byte("\\ka\\\\final\\\\persist\\1\\final\\\\status\\")
+
+	packets, rest := splitDataPackets(stream)
+	if len(packets) != 2 {
+		t.Fatalf("got %d packets, want 2", len(packets))
+	}
+	if string(rest) != "\\status\\" {
+		t.Errorf("rest = %q, want the unfinished packet", rest)
+	}
+}
+
+func FuzzParseDataPacket(f *testing.F) {
+	f.Add([]byte("\\login2\\196610\\username\\test@phantom-im.xyz\\response\\abc\\reconnect\\0\\status\\100\\id\\1\\final\\"))
+	f.Add([]byte("\\persist\\1\\sesskey\\1\\cmd\\514\\dsn\\0\\uid\\1\\lid\\9\\rid\\4\\body\\ContactID=2\x1cNickName=a/1b\x1c\\final\\"))
+	f.Add([]byte("\\status\\1\\statstring\\|s|1|ss|a/2b|\\final\\"))
+	f.Add([]byte("\\final\\"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packets, _ := splitDataPackets(data)
+		packets = append(packets, data)
+
+		for _, packet := range packets {
+			msg, ok := parseDataPacket(packet)
+			if !ok {
+				continue
+			}
+
+			for _, pair := range msg.pairs {
+				value := msg.get(pair.Key)
+				if again := unescapeValue(escapeValue(value, msim_escape_value)); again != value {
+					t.Errorf("value %q did not survive escaping, got %q", value, again)
+				}
+
+				msg.getDictionary(pair.Key)
+				msg.getList(pair.Key)
+			}
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package msim
+
+import (
+	"fmt"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+)
+
+const (
+	msim_visibility_visible = 1
+	msim_visibility_hidden  = 2
+)
+
+// seconds between 1601-01-01 and 1970-01-01, LastLogin is sent as a windows FILETIME
+const msim_filetime_epoch_offset = 11644473600
+
+func getFriendCount(uid int) int {
+	var count int
+
+	res, err := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id= ?", uid)
+	if err != nil {
+		util.Error("MySpace -> getFriendCount", err.Error())
+		return 0
+	}
+	res.Next()
+	res.Scan(&count)
+	res.Close()
+
+	return count
+}
+
+// contacts that restrict their profile to their own list stay hidden to everyone else
+func getContactVisibility(owner int, contact int, privacy global.Privacy) int {
+	if privacy.PrivacyMode != 0 && !global.IsContact(contact, owner) {
+		return msim_visibility_hidden
+	}
+	return msim_visibility_visible
+}
+
+// empty unless the user uploaded a picture, allows showing it and the viewer may see their profile
+func getAvatarUrl(viewer int, userid int, details msim_user_details, privacy global.Privacy) string {
+	if details.avatartype == "" || !privacy.ShowAvatar {
+		return ""
+	}
+	if viewer != userid && getContactVisibility(viewer, userid, privacy) != msim_visibility_visible {
+		return ""
+	}
+	return fmt.Sprintf("http://%s/pfp/id=%d.%s", util.GetRootUrl(), userid, details.avatartype)
+}
+
+// ShowOnlyToList and friends are sent capitalized
+func formatPrivacyFlag(value bool) string {
+	if value {
+		return "True"
+	}
+	return "False"
+}
+
+// lastlogin is stored in unix nanoseconds, 0 means never logged in
+func getLastLoginFileTime(lastlogin int64) int64 {
+	if lastlogin <= 0 {
+		return 0
+	}
+	return lastlogin/100 + msim_filetime_epoch_offset*10000000
+}
+
+func getContactSkyStatus(contact int, visibility int) int {
+	if visibility != msim_visibility_visible {
+		return msim_status_offline
+	}
+	if ctx := getUserContext(contact); ctx != nil {
+		return ctx.statuscode
+	}
+	return msim_status_offline
+}
+
+// the owner's custom nickname wins over the contact's own screenname
+func getContactNickName(acc global.Account, info global.ContactInfo) string {
+	if info.NickName != "" {
+		return info.NickName
+	}
+	return acc.Screenname
+}
+
+// persist 1;0;1 get_contact_information
+func handleClientPacketGetContactList(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	util.Debug("MySpace -> handleClientPacketGetContactList", "Requested Contact List...")
+	res, _ := util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id=?", client.Account.UserId)
+	var contacts []global.Contact
+	for res.Next() {
+		var contact global.Contact
+		_ = res.Scan(&contact.FromId, &contact.ToId)
+		contacts = append(contacts, contact)
+	}
+	res.Close()
+
+	body := ""
+	for i := 0; i < len(contacts); i++ {
+		accountRow, _ := global.GetUserDataFromUserId(contacts[i].ToId)
+		accountData, _ := getMySpaceDataByUserId(contacts[i].ToId)
+		privacy, _ := global.GetPrivacyFromUserId(contacts[i].ToId)
+		info, _ := global.GetContactInfo(client.Account.UserId, contacts[i].ToId)
+
+		visibility := getContactVisibility(client.Account.UserId, contacts[i].ToId, privacy)
+		avatarurl := getAvatarUrl(client.Account.UserId, accountRow.UserId, accountData, privacy)
+
+		entry := []msim_data_pair{
+			msim_new_data_int("ContactID", accountRow.UserId),
+			msim_new_data_string("Headline", accountData.headline),
+			msim_new_data_int("Position", 1),                //TODO
+			msim_new_data_string("GroupName", "IM Friends"), //TODO
+			msim_new_data_int("Visibility", visibility),
+			msim_new_data_string("ShowAvatar", strconv.FormatBool(avatarurl != "")),
+		}
+		if avatarurl != "" {
+			entry = append(entry, msim_new_data_string("AvatarUrl", avatarurl))
+		}
+		entry = append(entry,
+			msim_new_data_int64("LastLogin", getLastLoginFileTime(accountData.lastlogin)),
+			msim_new_data_string("IMName", accountRow.Email),
+			msim_new_data_string("NickName", getContactNickName(accountRow, info)),
+			msim_new_data_int("NameSelect", info.NameSelect),
+			msim_new_data_string("Notes", info.Notes),
+			msim_new_data_string("OfflineMsg", getPresenceByUserId(contacts[i].ToId).awaymessage),
+			msim_new_data_int("SkyStatus", getContactSkyStatus(contacts[i].ToId, visibility)),
+		)
+
+		body += buildDataBody(entry)
+	}
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, body))
+}
+
+// persist 1;0;2 get_contact_information
+func handleClientPacketGetContactInformation(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	util.Debug("MySpace -> handleClientPacketGetContactInformation", "Requesting Contact Information...")
+	parse, err := strconv.Atoi(req.body.first())
+	if err != nil {
+		handleClientPersistError(client, req, "Invalid contact id.")
+		return
+	}
+
+	accountRow, _ := global.GetUserDataFromUserId(parse)
+	accountData, _ := getMySpaceDataByUserId(parse)
+	privacy, _ := global.GetPrivacyFromUserId(parse)
+	info, _ := global.GetContactInfo(client.Account.UserId, parse)
+
+	avatarurl := getAvatarUrl(client.Account.UserId, accountRow.UserId, accountData, privacy)
+
+	body := []msim_data_pair{
+		msim_new_data_int("ContactID", accountRow.UserId),
+		msim_new_data_string("Headline", accountData.headline),
+		msim_new_data_int("Position", 1),                           //TODO
+		msim_new_data_string(ctx.field("GroupName"), "IM Friends"), //TODO
+		msim_new_data_int("Visibility", getContactVisibility(client.Account.UserId, parse, privacy)),
+		msim_new_data_string(ctx.field("ShowAvatar"), strconv.FormatBool(avatarurl != "")),
+	}
+	if avatarurl != "" {
+		body = append(body, msim_new_data_string(ctx.field("AvatarUrl"), avatarurl))
+	}
+	body = append(body,
+		msim_new_data_int(ctx.field("NameSelect"), info.NameSelect),
+		msim_new_data_string("IMName", accountRow.Email),
+		msim_new_data_string(ctx.field("NickName"), getContactNickName(accountRow, info)),
+		msim_new_data_string(ctx.field("Notes"), info.Notes),
+	)
+
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody(body)))
+}
+
+// persist 514;0;9 set_contact_information, fields missing from the body are left untouched
+func handleClientPacketSetContactInformation(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	contact, err := strconv.Atoi(req.body.get("ContactID"))
+	if err != nil || !global.IsContact(client.Account.UserId, contact) {
+		handleClientPersistError(client, req, "This user is not on your contact list.")
+		return
+	}
+
+	info, _ := global.GetContactInfo(client.Account.UserId, contact)
+
+	if req.body.has("NickName") {
+		info.NickName = req.body.get("NickName")
+	}
+	if req.body.has("Notes") {
+		info.Notes = req.body.get("Notes")
+	}
+	if req.body.has("NameSelect") {
+		info.NameSelect, _ = strconv.Atoi(req.body.get("NameSelect"))
+	}
+
+	if !global.SetContactInfo(info) {
+		handleClientPersistError(client, req, "The contact information could not be saved.")
+		return
+	}
+
+	util.Debug("MySpace -> handleClientPacketSetContactInformation", "%s updated contact %d", client.Account.Username, contact)
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_int("ContactID", contact),
+	})))
+}
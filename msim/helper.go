@@ -4,8 +4,6 @@ import (
 	"math/rand"
 	"phantom/global"
 	"phantom/util"
-	"strconv"
-	"strings"
 )
 
 func generateNonce() string {
@@ -57,44 +55,21 @@ func getMySpaceDataByUserId(uid int) (msim_user_details, bool) {
 	return user, true
 }
 
-func escapeString(data string) string {
-	res := strings.Replace(data, "/", "/1", -1)
-	res = strings.Replace(res, "\\", "\\2", -1)
-	return res
-}
-func unescapeString(data string) string {
-	res := strings.Replace(data, "/1", "/", -1)
-	res = strings.Replace(res, "\\2", "\\", -1)
-	return res
-}
-
 func addUserContext(ctx *msim_context) {
 	users_context = append(users_context, ctx)
 }
 
+// only returns authenticated sessions
+func getUserContext(uid int) *msim_context {
+	for i := 0; i < len(users_context); i++ {
+		if users_context[i].client != nil && users_context[i].client.Account.UserId == uid {
+			return users_context[i]
+		}
+	}
+	return nil
+}
+
 func removeUserContext(s []*msim_context, i int) []*msim_context {
 	s[i] = s[len(s)-1]
 	return s[:len(s)-1]
 }
-
-func identifyProtocolVersion(clientver string) string {
-	ver, _ := strconv.Atoi(clientver)
-
-	if ver <= 253 {
-		return "MSIMv1"
-	} else if ver > 253 && ver < 366 {
-		return "MSIMv2"
-	} else if ver > 366 && ver < 404 {
-		return "MSIMv3"
-	} else if ver > 404 && ver < 594 {
-		return "MSIMv4"
-	} else if ver > 593 && ver < 673 {
-		return "MSIMv5"
-	} else if ver > 673 && ver < 697 {
-		return "MSIMv6"
-	} else if ver > 697 && ver < 812 {
-		return "MSIMv7"
-	}
-
-	return ""
-}
@@ -0,0 +1,75 @@
+package msim
+
+import (
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	netlink templates can use the following placeholders
+
+	{root}     -> configured root url
+	{uid}      -> id of the requesting user
+	{username} -> username of the requesting user
+	{target}   -> user id the link is about (profile, add friend)
+	{token}    -> short lived web session token of the requesting user
+
+	the table can be overridden per link id with "netlinks" in config.json
+*/
+
+const msim_netlink_token_ttl = time.Hour
+
+var msim_default_netlinks = map[string]string{
+	"1": "http://{root}/home?token={token}",               // home
+	"2": "http://{root}/profile/{target}?token={token}",   // view profile
+	"3": "http://{root}/inbox?token={token}",              // inbox
+	"4": "http://{root}/profile/edit?token={token}",       // edit profile
+	"5": "http://{root}/addfriend/{target}?token={token}", // add friend
+	"6": "http://{root}/avatar?token={token}",             // change picture
+	"7": "http://{root}/bulletins?token={token}",          // bulletins
+}
+
+func getNetLinkTemplate(linkid string) (string, bool) {
+	if template, ok := util.GetNetLinks()[linkid]; ok {
+		return template, true
+	}
+
+	template, ok := msim_default_netlinks[linkid]
+	return template, ok
+}
+
+// Persist 1;6;11
+func handleClientPacketRequestNetLink(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	linkid := req.body.get("LinkID")
+	if linkid == "" {
+		linkid = req.body.first()
+	}
+
+	template, ok := getNetLinkTemplate(linkid)
+	if !ok {
+		util.Log("MySpace -> handleClientPacketRequestNetLink", "Unknown NetLink %s requested by %s (body: %s)", linkid, client.Account.Username, strings.Replace(buildDataBody(req.body), "\x1c", "|", -1))
+		handleClientPersistError(client, req, "This link is not available.")
+		return
+	}
+
+	target := req.body.get("UserID")
+	if target == "" {
+		target = strconv.Itoa(client.Account.UserId)
+	}
+
+	url := strings.NewReplacer(
+		"{root}", util.GetRootUrl(),
+		"{uid}", strconv.Itoa(client.Account.UserId),
+		"{username}", client.Account.Username,
+		"{target}", target,
+		"{token}", global.IssueToken("web", client.Account.UserId, msim_netlink_token_ttl),
+	).Replace(template)
+
+	util.Debug("MySpace -> handleClientPacketRequestNetLink", "NetLink %s -> %s", linkid, url)
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_string(ctx.field("URL"), url),
+	})))
+}
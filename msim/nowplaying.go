@@ -0,0 +1,53 @@
+package msim
+
+import (
+	"phantom/global"
+	"phantom/util"
+)
+
+func init() {
+	global.RegisterProfileNotifier("MySpaceIM", notifyProfile)
+}
+
+// pushed as an unrequested reply to persist 1;4;3, which clients use to refresh a buddy's profile
+func notifyProfile(target *global.Client, uid int) bool {
+	ctx := getUserContext(target.Account.UserId)
+	if ctx == nil {
+		return false
+	}
+
+	accountData, _ := getMySpaceDataByUserId(uid)
+
+	req := &msim_persist_request{cmd: msim_cmd_get, dsn: 4, lid: 3}
+	return util.WriteTraffic(target.Connection, buildPersistReply(target, req, buildDataBody([]msim_data_pair{
+		msim_new_data_int("UserID", uid),
+		msim_new_data_string("BandName", accountData.bandname),
+		msim_new_data_string("SongName", accountData.songname),
+	}))) == nil
+}
+
+// setinfo message, info holds the changed profile fields
+func handleClientPacketSetInfo(client *global.Client, packet *msim_message) {
+	info := packet.getDictionary("info")
+	if !info.has("BandName") && !info.has("SongName") {
+		return
+	}
+
+	accountData, _ := getMySpaceDataByUserId(client.Account.UserId)
+
+	band := accountData.bandname
+	if info.has("BandName") {
+		band = info.get("BandName")
+	}
+	song := accountData.songname
+	if info.has("SongName") {
+		song = info.get("SongName")
+	}
+
+	if !global.SetNowPlaying(client.Account.UserId, band, song) {
+		util.Debug("MySpace -> handleClientPacketSetInfo", "Rejected now playing update from %s", client.Account.Username)
+		return
+	}
+
+	util.Debug("MySpace -> handleClientPacketSetInfo", "%s is now playing %s - %s", client.Account.Username, band, song)
+}
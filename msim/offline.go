@@ -0,0 +1,178 @@
+package msim
+
+import (
+	"fmt"
+	"phantom/global"
+	"phantom/util"
+	"time"
+)
+
+const msim_offline_expiry_interval = time.Hour
+
+// HandleOfflineExpiry periodically drops offline messages that outlived the configured expiry
+func HandleOfflineExpiry() {
+	for {
+		expireOfflineMessages()
+		time.Sleep(msim_offline_expiry_interval)
+	}
+}
+
+func expireOfflineMessages() {
+	expiry := util.GetOfflineMessageExpiry()
+	if expiry <= 0 {
+		return
+	}
+
+	cutoff := time.Now().UTC().Add(-time.Duration(expiry) * time.Hour * 24).UnixMilli()
+	res, err := util.GetDatabaseHandle().Query("DELETE from offlinemsgs WHERE date < ?", cutoff)
+	if err != nil {
+		util.Error("MySpace -> expireOfflineMessages", err.Error())
+		return
+	}
+	res.Close()
+}
+
+func storeOfflineMessage(client *global.Client, to int, msg string, date int64) bool {
+	var count int
+	check, err := util.GetDatabaseHandle().Query("SELECT COUNT(*) from offlinemsgs WHERE to_id= ?", to)
+	if err != nil {
+		util.Error("MySpace -> storeOfflineMessage", err.Error())
+		return false
+	}
+	if check.Next() {
+		check.Scan(&count)
+	}
+	check.Close()
+
+	if quota := util.GetOfflineMessageQuota(); quota > 0 && count >= quota {
+		util.Debug("MySpace -> storeOfflineMessage", "Offline message quota of %d reached for %d", quota, to)
+		util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
+			msim_new_data_boolean("error", true),
+			msim_new_data_string("errmsg", "The recipient's offline message box is full."),
+			msim_new_data_int("err", 1),
+		}))
+		return false
+	}
+
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO offlinemsgs (`from_id`, `to_id`, `message`, `date`) VALUES (?, ?, ?, ?)", client.Account.UserId, to, msg, date)
+	if err != nil {
+		util.Error("MySpace -> storeOfflineMessage", err.Error())
+		return false
+	}
+	res.Close()
+
+	return true
+}
+
+// handle offline messages
+func handleClientHandleOfflineMessages(client *global.Client, ctx *msim_context) {
+	var msgs []global.OfflineMsg
+	res, err := util.GetDatabaseHandle().Query("SELECT id, from_id, to_id, message, date from offlinemsgs WHERE to_id= ? ORDER BY date", client.Account.UserId)
+	if err != nil {
+		util.Error("MySpace -> handleClientHandleOfflineMessages", err.Error())
+		return
+	}
+	for res.Next() {
+		var msg global.OfflineMsg
+		_ = res.Scan(&msg.Id, &msg.FromId, &msg.ToId, &msg.Message, &msg.Date)
+		msgs = append(msgs, msg)
+	}
+	res.Close()
+
+	for i := 0; i < len(msgs); i++ {
+		err := util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
+			msim_new_data_int("bm", 1),
+			msim_new_data_int("sesskey", ctx.sesskey),
+			msim_new_data_int("f", msgs[i].FromId),
+			msim_new_data_int64("date", msgs[i].Date/1000),
+			msim_new_data_boolean("offline", true),
+			msim_new_data_string("msg", msgs[i].Message),
+		}))
+		if err != nil {
+			util.Debug("MySpace -> handleClientOfflineEvents", "Delivery of offline message %d failed, keeping it", msgs[i].Id)
+			return
+		}
+
+		res, err := util.GetDatabaseHandle().Query("DELETE from offlinemsgs WHERE id= ?", msgs[i].Id)
+		if err != nil {
+			util.Error("MySpace -> handleClientHandleOfflineMessages", err.Error())
+			return
+		}
+		res.Close()
+
+		handleClientOfflineMessageDelivered(msgs[i])
+	}
+}
+
+// notifies the sender right away or keeps a receipt for their next login
+func handleClientOfflineMessageDelivered(msg global.OfflineMsg) {
+	// receipts need a system account to come from, nobody else gets to speak for the recipient
+	if util.GetSystemUserId() == 0 {
+		return
+	}
+
+	if sender := getUserContext(msg.FromId); sender != nil {
+		if sendOfflineReceipt(sender, msg.ToId, msg.Date) {
+			return
+		}
+	}
+
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO offlinereceipts (`from_id`, `to_id`, `date`) VALUES (?, ?, ?)", msg.FromId, msg.ToId, msg.Date)
+	if err != nil {
+		util.Error("MySpace -> handleClientOfflineMessageDelivered", err.Error())
+		return
+	}
+	res.Close()
+}
+
+func sendOfflineReceipt(ctx *msim_context, to int, date int64) bool {
+	sent := time.UnixMilli(date).UTC().Format("2006-01-02 15:04")
+
+	name := "your contact"
+	if acc, ok := global.GetUserDataFromUserId(to); ok && acc.UserId != 0 {
+		name = acc.Screenname
+	}
+
+	// stock clients only show bm 1, so the receipt arrives as a message from the system account
+	err := util.WriteTraffic(ctx.client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_int("bm", msim_bm_action_or_im_delayable),
+		msim_new_data_int("sesskey", ctx.sesskey),
+		msim_new_data_int("f", util.GetSystemUserId()),
+		msim_new_data_string("msg", fmt.Sprintf("Your offline message to %s from %s UTC has been delivered.", name, sent)),
+	}))
+
+	return err == nil
+}
+
+// delivery receipts for offline messages sent while this user was away
+func handleClientHandleOfflineReceipts(client *global.Client, ctx *msim_context) {
+	if util.GetSystemUserId() == 0 {
+		return
+	}
+
+	var receipts []global.OfflineMsg
+	res, err := util.GetDatabaseHandle().Query("SELECT id, to_id, date from offlinereceipts WHERE from_id= ? ORDER BY date", client.Account.UserId)
+	if err != nil {
+		util.Error("MySpace -> handleClientHandleOfflineReceipts", err.Error())
+		return
+	}
+	for res.Next() {
+		var receipt global.OfflineMsg
+		_ = res.Scan(&receipt.Id, &receipt.ToId, &receipt.Date)
+		receipts = append(receipts, receipt)
+	}
+	res.Close()
+
+	for i := 0; i < len(receipts); i++ {
+		if !sendOfflineReceipt(ctx, receipts[i].ToId, receipts[i].Date) {
+			return
+		}
+
+		res, err := util.GetDatabaseHandle().Query("DELETE from offlinereceipts WHERE id= ?", receipts[i].Id)
+		if err != nil {
+			util.Error("MySpace -> handleClientHandleOfflineReceipts", err.Error())
+			return
+		}
+		res.Close()
+	}
+}
@@ -11,8 +11,6 @@ import (
 	"time"
 )
 
-var pfproot string = util.GetRootUrl()
-
 /*
 	msim_not_a_packet   = -2       	-> garbage
 	msim_unknown_packet = -1       	-> unknown packet
@@ -26,77 +24,26 @@ var pfproot string = util.GetRootUrl()
 	msim_login_challenge  			-> login2
 	msim_logout           			-> logout
 	msim_callback_request 			-> persist
+	msim_set_info         			-> setinfo
 */
 
-func handleClientIncomingPersistPackets(client *global.Client, ctx *msim_context, data []byte) {
-	str := string(data)
-
-	if strings.Contains(str, "\\persist\\1") {
-		if strings.Contains(str, "\\cmd\\1") {
-			if strings.Contains(str, "\\dsn\\0") {
-				if strings.Contains(str, "\\lid\\1") {
-					handleClientPacketGetContactList(client, data)
-				}
-
-				if strings.Contains(str, "\\lid\\2") {
-					handleClientPacketGetContactInformation(client, data)
-				}
-
-			}
-			if strings.Contains(str, "\\dsn\\1") {
-				if strings.Contains(str, "lid\\4") {
-					handleClientPacketUserLookupIMAboutMyself(client, data)
-				}
-
-				if strings.Contains(str, "lid\\7") || strings.Contains(str, "lid\\17") {
-					handleClientPacketUserLookupIMByUid(client, data)
-				}
-			}
-
-			if strings.Contains(str, "\\dsn\\2") && strings.Contains(str, "\\lid\\6") {
-				handleClientPacketGetGroups(client, data)
-			}
-
-			if strings.Contains(str, "\\dsn\\4") {
-				if strings.Contains(str, "lid\\3") || strings.Contains(str, "lid\\5") {
-					handleClientPacketUserLookupMySpaceByUid(client, data)
-				}
-			}
-
-			if strings.Contains(str, "\\dsn\\5") && strings.Contains(str, "\\lid\\7") {
-				handleClientPacketUserLookupMySpaceByUsernameOrEmail(client, data)
-			}
-
-			if strings.Contains(str, "\\dsn\\6") && strings.Contains(str, "\\lid\\11") {
-				handleClientPacketRequestNetLink(client, data)
-			}
-
-			if strings.Contains(str, "\\dsn\\7") && strings.Contains(str, "\\lid\\18") {
-				handleClientPacketNewNotificationRequest(client, data)
-			}
-		}
-		if strings.Contains(str, "\\cmd\\514") || strings.Contains(str, "\\cmd\\2") {
-			if strings.Contains(str, "\\dsn\\8") && strings.Contains(str, "\\lid\\13") {
-				handleClientPacketChangePicture(client, data)
-			}
+func handleClientIncomingPackets(client *global.Client, ctx *msim_context, msg *msim_message) {
+	switch msg.command() {
+	case "status":
+		handleClientPacketSetStatusMessages(client, ctx, msg)
+	case "addbuddy":
+		handleClientPacketAddBuddy(client, ctx, msg)
+	case "delbuddy":
+		handleClientPacketDelBuddy(client, ctx, msg)
+	case "bm":
+		switch msg.getInt("bm") {
+		case msim_bm_action_or_im_delayable, msim_bm_action_or_im_instant:
+			handleClientPacketBuddyInstantMessage(client, ctx, msg)
 		}
-	}
-}
-
-func handleClientIncomingPackets(client *global.Client, ctx *msim_context, data []byte) {
-	str := string(data)
-
-	if strings.Contains(str, "\\status") {
-		handleClientPacketSetStatusMessages(client, ctx, data)
-	}
-	if strings.Contains(str, "\\addbuddy") {
-		handleClientPacketAddBuddy(client, ctx, data)
-	}
-	if strings.Contains(str, "\\delbuddy") {
-		handleClientPacketDelBuddy(client, data)
-	}
-	if strings.Contains(str, "\\bm\\1") {
-		handleClientPacketBuddyInstantMessage(client, ctx, data)
+	case "persist":
+		handleClientIncomingPersistPackets(client, ctx, msg)
+	case "setinfo":
+		handleClientPacketSetInfo(client, msg)
 	}
 }
 
@@ -120,18 +67,43 @@ func handleClientAuthentication(client *global.Client, ctx *msim_context) bool {
 		msim_new_data_string("id", "1"),
 	}))
 
-	loginpacket, success := util.ReadTraffic(client.Connection)
+	data, success := util.ReadTraffic(client.Connection)
 	if !success {
 		util.Error("MySpace -> handleClientAuthentication", "Failed to read Login2 Data Packet!")
 		return false
 	}
 
-	username := findValueFromKey("username", loginpacket)
-	version := findValueFromKey("clientver", loginpacket)
+	packets, _ := splitDataPackets(data)
+	if len(packets) == 0 {
+		util.Error("MySpace -> handleClientAuthentication", "Received incomplete Login2 Data Packet!")
+		return false
+	}
+
+	loginpacket, ok := parseDataPacket(packets[0])
+	if !ok || loginpacket.command() != "login2" {
+		util.Error("MySpace -> handleClientAuthentication", "Received malformed Login2 Data Packet!")
+		return false
+	}
+
+	username := loginpacket.get("username")
+	version := loginpacket.get("clientver")
+
+	build, ok := identifyClientBuild(version)
+	if !ok {
+		util.Log("MySpaceIM", "Refused unsupported client -> Username: %s, Version: 1.0.%s.0", username, version)
+		util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
+			msim_new_data_boolean("error", true),
+			msim_new_data_string("errmsg", getUpgradeMessage()),
+			msim_new_data_string("err", "1"),
+			msim_new_data_boolean("fatal", true),
+		}))
+		return false
+	}
 
 	acc, _ := global.GetUserDataFromUsername(username)
 	client.Account = acc
-	client.Protocol = identifyProtocolVersion(version)
+	client.Protocol = build.protocol
+	ctx.build = build
 
 	uid := acc.UserId
 	screenname := acc.Screenname
@@ -164,7 +136,7 @@ func handleClientAuthentication(client *global.Client, ctx *msim_context) bool {
 	for i := 0; i < 16; i++ {
 		byte_rc4_key[i] = byte_hash_total[i]
 	}
-	packetrc4data := findValueFromKey("response", loginpacket)
+	packetrc4data := loginpacket.get("response")
 	byte_rc4_data, err := base64.StdEncoding.DecodeString(packetrc4data)
 	if err != nil {
 		util.Error("MySpace -> handleClientAuthentication", "Invalid base64 provided at login packet.")
@@ -189,7 +161,6 @@ func handleClientAuthentication(client *global.Client, ctx *msim_context) bool {
 		}))
 
 		client.BuildNumber = fmt.Sprintf("1.0.%s.0", version)
-		client.Protocol = "MSIMv?"
 
 		return true
 	} else {
@@ -205,90 +176,27 @@ func handleClientAuthentication(client *global.Client, ctx *msim_context) bool {
 
 // broadcast sign on status
 func handleClientBroadcastSignOnStatus(client *global.Client, ctx *msim_context) {
-	for i := 0; i < len(global.Clients); i++ {
-		if global.Clients[i].Account.UserId != client.Account.UserId {
-			res, _ := util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id= ?", client.Account.UserId)
-			for res.Next() {
-				var msg global.Contact
-				_ = res.Scan(&msg.FromId, &msg.ToId)
-				if global.Clients[i].Account.UserId == msg.ToId {
-					res2, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id= ? AND to_id= ?", global.Clients[i].Account.UserId, client.Account.UserId)
-					res2.Next()
-					var count int
-					res2.Scan(&count)
-					res2.Close()
-					if count > 0 {
-						util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-							msim_new_data_int("bm", 100),
-							msim_new_data_int("f", client.Account.UserId),
-							msim_new_data_string("msg", fmt.Sprintf("|s|%d|ss|%s", ctx.statuscode, ctx.statusmessage)),
-							//msim_new_data_string("msg", "|s|"+ctx.StatusCode+"|ss|"+client.StatusText),
-						}))
-						util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
-							msim_new_data_int("bm", 100),
-							msim_new_data_int("f", global.Clients[i].Account.UserId),
-							msim_new_data_string("msg", fmt.Sprintf("|s|%d|ss|%s", users_context[i].statuscode, users_context[i].statusmessage)),
-							//msim_new_data_string("msg", "|s|"+Msim_Clients[i].StatusCode+"|ss|"+Msim_Clients[i].StatusText),
-						}))
-					}
-				}
-			}
-			res.Close()
+	ctx.loadPresence()
+	ctx.storePresence()
+
+	contacts := getMutualContacts(client.Account.UserId)
+	for i := 0; i < len(contacts); i++ {
+		if contact := getUserContext(contacts[i]); contact != nil {
+			sendStatusUpdate(contact, client.Account.UserId, ctx.visibleStatus())
+			sendStatusUpdate(ctx, contact.client.Account.UserId, contact.visibleStatus())
 		}
 	}
 }
 
 // broadcast sign off events
 func handleClientBroadcastSignOffStatus(client *global.Client, ctx *msim_context) {
-	for i := 0; i < len(global.Clients); i++ {
-		if global.Clients[i].Account.UserId != client.Account.UserId {
-			res, _ := util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id= ?", client.Account.UserId)
-			for res.Next() {
-				var msg global.Contact
-				_ = res.Scan(&msg.FromId, &msg.ToId)
-				if global.Clients[i].Account.UserId == msg.ToId {
-					res2, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id= ? AND to_id= ?", global.Clients[i].Account.UserId, client.Account.UserId)
-					res2.Next()
-					var count int
-					res2.Scan(&count)
-					res2.Close()
-					if count > 0 {
-						util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-							msim_new_data_int("bm", 100),
-							msim_new_data_int("f", client.Account.UserId),
-							msim_new_data_string("msg", fmt.Sprintf("|s|0|ss|%s", ctx.statusmessage)),
-						}))
-					}
-
-				}
-			}
-			res.Close()
-		}
-	}
-}
-
-// handle offline messages
-func handleClientHandleOfflineMessages(client *global.Client, ctx *msim_context) {
-	res, _ := util.GetDatabaseHandle().Query("SELECT * from offlinemsgs WHERE to_id= ?", client.Account.UserId)
-	for res.Next() {
-		var msg global.OfflineMsg
-		_ = res.Scan(&msg.FromId, &msg.ToId, &msg.Message, &msg.Date)
-		util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
-			msim_new_data_int("bm", 1),
-			msim_new_data_int("sesskey", ctx.sesskey),
-			msim_new_data_int("f", msg.FromId),
-			//	msim_new_data_int("date", msg.date),
-			msim_new_data_string("msg", msg.Message),
-		}))
-		util.Debug("MySpace -> handleClientOfflineEvents", "%d", msg.Date)
-	}
-	res.Close()
-	res2, _ := util.GetDatabaseHandle().Query("DELETE from offlinemsgs WHERE to_id= ?", client.Account.UserId)
-	res2.Close()
+	ctx.setStatus(msim_status_offline, ctx.statusmessage)
+	ctx.storePresence()
+	handleClientBroadcastStatus(ctx)
 }
 
-func handleClientLogoutRequest(data string) bool {
-	if strings.HasPrefix(data, "\\logout") {
+func handleClientLogoutRequest(msg *msim_message) bool {
+	if msg.command() == "logout" {
 		return true
 	} else {
 		return false
@@ -296,49 +204,19 @@ func handleClientLogoutRequest(data string) bool {
 }
 
 // Status Messages
-func handleClientPacketSetStatusMessages(client *global.Client, ctx *msim_context, packet []byte) {
-	status := findValueFromKey("status", packet)
-	statstring := findValueFromKey("statstring", packet)
-
-	ctx.statuscode, _ = strconv.Atoi(status)
-	ctx.statusmessage = statstring
-	res, _ := util.GetDatabaseHandle().Query("UPDATE myspace SET headline= ? WHERE id= ?", statstring, client.Account.UserId)
-	res.Close()
-	for i := 0; i < len(global.Clients); i++ {
-		if global.Clients[i].Account.UserId != client.Account.UserId {
-			res, _ := util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id= ?", client.Account.UserId)
-			for res.Next() {
-				var msg global.Contact
-				_ = res.Scan(&msg.FromId, &msg.ToId)
-				if global.Clients[i].Account.UserId == msg.ToId {
-					res2, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id= ? AND to_id= ?", global.Clients[i].Account.UserId, client.Account.UserId)
-					res2.Next()
-					var count int
-					res2.Scan(&count)
-					res2.Close()
-					if count > 0 {
-						util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-							msim_new_data_int("bm", 100),
-							msim_new_data_int("f", client.Account.UserId),
-							msim_new_data_string("msg", fmt.Sprintf("|s|%s|ss|%s", status, statstring)),
-							//msim_new_data_string("msg", "|s|"+status+"|ss|"+statstring+""),
-						}))
-					}
-
-				}
-			}
-			res.Close()
-		}
-	}
+func handleClientPacketSetStatusMessages(client *global.Client, ctx *msim_context, packet *msim_message) {
+	ctx.setStatus(packet.getInt("status"), packet.get("statstring"))
+	ctx.storePresence()
+	handleClientBroadcastStatus(ctx)
 }
 
 // addbuddy message
-func handleClientPacketAddBuddy(client *global.Client, ctx *msim_context, packet []byte) {
-	if findValueFromKey("newprofileid", packet) == "6221" {
+func handleClientPacketAddBuddy(client *global.Client, ctx *msim_context, packet *msim_message) {
+	if packet.get("newprofileid") == "6221" {
 		util.Debug("MySpace -> handleClientPacketAddBuddy", "MySpace Chatbot Friend Request Detected! Skipping...")
 		return
 	}
-	newprofileid := findValueFromKey("newprofileid", packet)
+	newprofileid := packet.get("newprofileid")
 
 	var count int
 	check, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE to_id=? and from_id= ?", newprofileid, client.Account.UserId)
@@ -357,405 +235,128 @@ func handleClientPacketAddBuddy(client *global.Client, ctx *msim_context, packet
 	util.Debug("addbuddy", "%d:%d", client.Account.UserId, newprofileid)
 	dbres, _ := util.GetDatabaseHandle().Query("INSERT into contacts (`from_id`, `to_id`) VALUES (?, ?)", client.Account.UserId, newprofileid)
 	dbres.Close()
+	newid, _ := strconv.Atoi(newprofileid)
+	global.IncrementListVersion(client.Account.UserId)
+	global.IncrementListVersion(newid)
 	var count2 int
 	check2, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE from_id=? and to_id= ?", newprofileid, client.Account.UserId)
 	check2.Next()
 	check2.Scan(&count2)
 	check2.Close()
 	if count2 > 0 {
-		for i := 0; i < len(global.Clients); i++ {
-			if strconv.Itoa(global.Clients[i].Account.UserId) == newprofileid {
-				util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
-					msim_new_data_int("bm", 100),
-					msim_new_data_int("f", global.Clients[i].Account.UserId),
-					msim_new_data_string("msg", fmt.Sprintf("|s|%d|ss|%s", users_context[i].statuscode, users_context[i].statusmessage)),
-					//msim_new_data_string("msg", "|s|"+[i].StatusCode+"|ss|"+Msim_Clients[i].StatusText),
-				}))
-				util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-					msim_new_data_int("bm", 100),
-					msim_new_data_int("f", client.Account.UserId),
-					msim_new_data_string("msg", fmt.Sprintf("|s|%d|ss|%s", ctx.statuscode, ctx.statusmessage)),
-				}))
-			}
+		if contact := getUserContext(newid); contact != nil {
+			sendStatusUpdate(ctx, newid, contact.visibleStatus())
+			sendStatusUpdate(contact, client.Account.UserId, ctx.visibleStatus())
 		}
 	}
 }
 
 // delbuddy message
-func handleClientPacketDelBuddy(client *global.Client, packet []byte) {
-	delprofileid := findValueFromKey("delprofileid", packet)
+func handleClientPacketDelBuddy(client *global.Client, ctx *msim_context, packet *msim_message) {
+	delprofileid := packet.get("delprofileid")
 	dbres, _ := util.GetDatabaseHandle().Query("DELETE from contacts WHERE to_id=? and from_id= ?", delprofileid, client.Account.UserId)
 	dbres.Close()
-	for i := 0; i < len(global.Clients); i++ {
-		if strconv.Itoa(global.Clients[i].Account.UserId) == delprofileid {
-			var count int
-			dbres, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE to_id=? and from_id= ?", client.Account.UserId, delprofileid)
-			dbres.Next()
-			dbres.Scan(&count)
-			dbres.Close()
-			if count > 0 {
-				util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-					msim_new_data_int("bm", 100),
-					msim_new_data_int("f", client.Account.UserId),
-					msim_new_data_string("msg", "|s|0|ss|Offline"),
-				}))
-			}
-		}
-	}
-}
-
-// bm type 1
-func handleClientPacketBuddyInstantMessage(client *global.Client, ctx *msim_context, packet []byte) {
-	t, _ := strconv.Atoi(findValueFromKey("t", packet))
-	msg := findValueFromKey("msg", packet)
-	date := time.Now().UTC().UnixMilli()
-	found := false
-	for i := 0; i < len(global.Clients); i++ {
-		if global.Clients[i].Account.UserId == t {
-			found = true
-			util.WriteTraffic(global.Clients[i].Connection, buildDataPacket([]msim_data_pair{
-				msim_new_data_int("bm", 1),
-				msim_new_data_int("sesskey", users_context[i].sesskey),
-				msim_new_data_int("f", client.Account.UserId),
-				msim_new_data_string("msg", msg),
-			}))
-		}
-	}
-	if !found {
-		if !strings.Contains(msg, "%typing%") && !strings.Contains(msg, "%stoptyping%") {
-			res, _ := util.GetDatabaseHandle().Query("INSERT INTO offlinemsgs (`from_id`, `to_id`, `message`, `date`) VALUES (?, ?, ?, ?)", client.Account.UserId, t, msg, date)
-			res.Close()
-		}
+	delid, _ := strconv.Atoi(delprofileid)
+	global.DeleteContactInfo(client.Account.UserId, delid)
+	global.IncrementListVersion(client.Account.UserId)
+	global.IncrementListVersion(delid)
+
+	// the removed contact now sees us the way offline contacts do
+	if contact := getUserContext(delid); contact != nil && global.IsContact(delid, client.Account.UserId) {
+		sendStatusUpdate(contact, client.Account.UserId, ctx.offlineStatus())
 	}
 }
 
-// persist 1;0;1 get_contact_information
-func handleClientPacketGetContactList(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-	util.Debug("MySpace -> handleClientPacketGetContactList", "Requested Contact List...")
-	res, _ := util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id=?", client.Account.UserId)
-	body := ""
-	for res.Next() {
-		var contact global.Contact
-		_ = res.Scan(&contact.FromId, &contact.ToId)
-
-		accountRow, _ := global.GetUserDataFromUserId(contact.ToId)
-		accountData, _ := getMySpaceDataByUserId(contact.ToId)
-
-		body += buildDataBody([]msim_data_pair{
-			msim_new_data_int("ContactID", accountRow.UserId),
-			msim_new_data_string("Headline", accountData.headline),
-			msim_new_data_int("Position", 1),                //TODO
-			msim_new_data_string("GroupName", "IM Friends"), //TODO
-			msim_new_data_int("Visibility", 1),
-			msim_new_data_string("ShowAvatar", "true"),
-			msim_new_data_string("AvatarUrl", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_int64("LastLogin", accountData.lastlogin),
-			msim_new_data_string("IMName", accountRow.Email),
-			msim_new_data_string("NickName", accountRow.Screenname),
-			msim_new_data_int("NameSelect", 0),
-			msim_new_data_string("OfflineMsg", "im offline"),
-			msim_new_data_int("SkyStatus", 0),
-		})
-	}
-	res.Close()
-	resp := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", body),
-	})
-	util.WriteTraffic(client.Connection, resp)
-}
-
-// persist 1;0;2 get_contact_information
-func handleClientPacketGetContactInformation(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
-	parsedbody := strings.Split(findValueFromKey("body", packet), "=")
-
-	util.Debug("MySpace -> handleClientPacketGetContactInformation", "Requesting Contact Information...")
-	parse, _ := strconv.Atoi(parsedbody[1])
-
-	accountRow, _ := global.GetUserDataFromUserId(parse)
-	accountData, _ := getMySpaceDataByUserId(parse)
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_int("ContactID", accountRow.UserId),
-			msim_new_data_string("Headline", accountData.headline),
-			msim_new_data_int("Position", 1),                 //TODO
-			msim_new_data_string("!GroupName", "IM Friends"), //TODO
-			msim_new_data_int("Visibility", 1),
-			msim_new_data_string("!ShowAvatar", "true"),
-			msim_new_data_string("!AvatarUrl", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_int("!NameSelect", 0),
-			msim_new_data_string("IMName", accountRow.Email),
-			msim_new_data_string("!NickName", accountRow.Screenname),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
-}
-
 // Persist 1;1;4
-func handleClientPacketUserLookupIMAboutMyself(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
+func handleClientPacketUserLookupIMAboutMyself(client *global.Client, ctx *msim_context, req *msim_persist_request) {
 	parse := client.Account.UserId
 
 	accountRow, _ := global.GetUserDataFromUserId(parse)
 	accountData, _ := getMySpaceDataByUserId(parse)
-
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_int("UserID", accountRow.UserId),
-			msim_new_data_string("Sound", "true"),
-			msim_new_data_int("!PrivacyMode", 0),
-			msim_new_data_string("!ShowOnlyToList", "False"),
-			msim_new_data_int("!OfflineMessageMode", 2),
-			msim_new_data_string("Headline", accountData.headline),
-			msim_new_data_string("Avatarurl", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_int("Alert", 1),
-			msim_new_data_string("!ShowAvatar", "true"),
-			msim_new_data_string("IMName", accountRow.Screenname),
-			msim_new_data_int("!ClientVersion", 999),
-			msim_new_data_string("!AllowBrowse", "true"),
-			msim_new_data_string("IMLang", "English"),
-			msim_new_data_int("LangID", 8192),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
+	privacy, _ := global.GetPrivacyFromUserId(parse)
+
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_int("UserID", accountRow.UserId),
+		msim_new_data_string("Sound", "true"),
+		msim_new_data_int(ctx.field("PrivacyMode"), privacy.PrivacyMode),
+		msim_new_data_string(ctx.field("ShowOnlyToList"), formatPrivacyFlag(privacy.PrivacyMode != 0)),
+		msim_new_data_int(ctx.field("OfflineMessageMode"), 2),
+		msim_new_data_string("Headline", accountData.headline),
+		msim_new_data_string("Avatarurl", getAvatarUrl(client.Account.UserId, accountRow.UserId, accountData, privacy)),
+		msim_new_data_int("Alert", 1),
+		msim_new_data_string(ctx.field("ShowAvatar"), strconv.FormatBool(privacy.ShowAvatar)),
+		msim_new_data_string("IMName", accountRow.Screenname),
+		msim_new_data_int(ctx.field("ClientVersion"), 999),
+		msim_new_data_string(ctx.field("AllowBrowse"), strconv.FormatBool(privacy.Searchable)),
+		msim_new_data_string("IMLang", "English"),
+		msim_new_data_int("LangID", 8192),
+	})))
 }
 
-// Persist 1;1;17
-func handleClientPacketUserLookupIMByUid(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
-	parsedbody := strings.Split(findValueFromKey("body", packet), "=")
-	parse, _ := strconv.Atoi(parsedbody[1])
+// Persist 1;1;7, 1;1;17
+func handleClientPacketUserLookupIMByUid(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	parse, err := strconv.Atoi(req.body.first())
+	if err != nil {
+		handleClientPersistError(client, req, "Invalid user id.")
+		return
+	}
 
 	accountRow, _ := global.GetUserDataFromUserId(parse)
 	accountData, _ := getMySpaceDataByUserId(parse)
-
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_int("UserID", accountRow.UserId),
-			msim_new_data_string("Sound", "true"),
-			msim_new_data_int("!PrivacyMode", 0),             // TODO
-			msim_new_data_string("!ShowOnlyToList", "False"), // TODO
-			msim_new_data_int("!OfflineMessageMode", 2),      // TODO
-			msim_new_data_string("Headline", accountData.headline),
-			msim_new_data_string("Avatarurl", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_int("Alert", 1),               //TODO
-			msim_new_data_string("!ShowAvatar", "true"), // TODO
-			msim_new_data_string("IMName", accountRow.Screenname),
-			msim_new_data_int("!ClientVersion", 999),
-			msim_new_data_string("!AllowBrowse", "true"), // TODO
-			msim_new_data_string("IMLang", "English"),
-			msim_new_data_int("LangID", 8192),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
+	privacy, _ := global.GetPrivacyFromUserId(parse)
+
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_int("UserID", accountRow.UserId),
+		msim_new_data_string("Sound", "true"),
+		msim_new_data_int(ctx.field("PrivacyMode"), privacy.PrivacyMode),
+		msim_new_data_string(ctx.field("ShowOnlyToList"), formatPrivacyFlag(privacy.PrivacyMode != 0)),
+		msim_new_data_int(ctx.field("OfflineMessageMode"), 2), // TODO
+		msim_new_data_string("Headline", accountData.headline),
+		msim_new_data_string("Avatarurl", getAvatarUrl(client.Account.UserId, accountRow.UserId, accountData, privacy)),
+		msim_new_data_int("Alert", 1), //TODO
+		msim_new_data_string(ctx.field("ShowAvatar"), strconv.FormatBool(privacy.ShowAvatar)),
+		msim_new_data_string("IMName", accountRow.Screenname),
+		msim_new_data_int(ctx.field("ClientVersion"), 999),
+		msim_new_data_string(ctx.field("AllowBrowse"), strconv.FormatBool(privacy.Searchable)),
+		msim_new_data_string("IMLang", "English"),
+		msim_new_data_int("LangID", 8192),
+	})))
 }
 
 // persist 1;2;6
 // \persist\1\sesskey\7920\cmd\1\dsn\2\uid\1\lid\6\rid\8\body\\final\
-func handleClientPacketGetGroups(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
+func handleClientPacketGetGroups(client *global.Client, ctx *msim_context, req *msim_persist_request) {
 	util.Debug("MySpace -> handleClientPacketGetGroups", "Requesting Contact Groups")
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_int("GroupID", 21672248),
-			msim_new_data_string("GroupName", "IM Friends"),
-			msim_new_data_int("Position", 1),
-			msim_new_data_int("GroupFlag", 131073),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_int("GroupID", 21672248),
+		msim_new_data_string("GroupName", "IM Friends"),
+		msim_new_data_int("Position", 1),
+		msim_new_data_int("GroupFlag", 131073),
+	})))
 }
 
 // Persist 1;4;3, 1;4;5
-func handleClientPacketUserLookupMySpaceByUid(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-	parsedbody := strings.Split(findValueFromKey("body", packet), "=")
-
-	parse, _ := strconv.Atoi(parsedbody[1])
-	accountRow, _ := global.GetUserDataFromUserId(parse)
-	accountData, _ := getMySpaceDataByUserId(parse)
-
-	util.Debug("MySpace -> handleClientPacketUserLookupMySpaceByUid", "http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype)
-
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_string("UserName", accountRow.Email),
-			msim_new_data_int("UserID", accountRow.UserId),
-			msim_new_data_string("ImageURL", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_string("DisplayName", accountRow.Screenname),
-			msim_new_data_string("BandName", accountData.bandname),
-			msim_new_data_string("SongName", accountData.songname),
-			msim_new_data_int("Age", accountData.age),
-			msim_new_data_string("Gender", accountData.gender),
-			msim_new_data_string("Location", accountData.location),
-			msim_new_data_int("!TotalFriends", 1), //TODO
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
-}
-
-// Persist 1;5;7
-func handleClientPacketUserLookupMySpaceByUsernameOrEmail(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
-	parsedbody := strings.Split(findValueFromKey("body", packet), "=")
-	accountRow, _ := global.GetUserDataFromEmail(parsedbody[1])
-	accountData, _ := getMySpaceDataByEmail(parsedbody[1])
-
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_string(parsedbody[0], parsedbody[1]),
-			msim_new_data_int("UserID", accountRow.UserId),
-			msim_new_data_string("ImageURL", escapeString(fmt.Sprintf("http://%s/pfp/id=%d.%s", pfproot, accountRow.UserId, accountData.avatartype))),
-			msim_new_data_string("DisplayName", accountRow.Screenname),
-			msim_new_data_string("BandName", accountData.bandname),
-			msim_new_data_string("SongName", accountData.songname),
-			msim_new_data_int("Age", accountData.age),
-			msim_new_data_string("Gender", accountData.gender),
-			msim_new_data_string("Location", accountData.location),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
-}
-
-// Persist 1;6;11
-func handleClientPacketRequestNetLink(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-
-	//test
-	res := buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", findValueFromKey("dsn", packet)),
-		msim_new_data_string("lid", findValueFromKey("lid", packet)),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-			msim_new_data_string("!URL", escapeString("http://google.de")),
-		})),
-	})
-	util.WriteTraffic(client.Connection, res)
-}
-
-// Persist 1;7;18
-func handleClientPacketNewNotificationRequest(client *global.Client, packet []byte) {
-	/*	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-
-		//test
-		res := buildDataPacket([]msim_data_pair{
-			msim_new_data_boolean("persistr", true),
-			msim_new_data_int("uid", client.Account.UserId),
-			msim_new_data_int("cmd", cmd^256),
-			msim_new_data_string("dsn", findValueFromKey("dsn", packet)),
-			msim_new_data_string("lid", findValueFromKey("lid", packet)),
-			msim_new_data_string("rid", findValueFromKey("rid", packet)),
-			msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
-				msim_new_data_string("FriendRequest", "On"),
-			})),
-		})
-		util.WriteTraffic(client.Connection, res)
-	*/
-}
-
-var buf []byte
-
-// persist 514;8;13 2;8;13 change_profile_picture
-func handleClientPacketChangePicture(client *global.Client, packet []byte) {
-	cmd, _ := strconv.Atoi(findValueFromKey("cmd", packet))
-	dsn := findValueFromKey("dsn", packet)
-	lid := findValueFromKey("lid", packet)
-
-	body := strings.Split(strings.Replace(findValueFromKey("body", packet), "\x1c", "", -1), "=")
-	part, err := base64.StdEncoding.DecodeString(unescapeString(body[len(body)-1]))
+func handleClientPacketUserLookupMySpaceByUid(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	parse, err := strconv.Atoi(req.body.first())
 	if err != nil {
+		handleClientPersistError(client, req, "Invalid user id.")
 		return
 	}
-	buf = append(buf, part...)
-
-	if strings.Contains(findValueFromKey("body", packet), "True") {
-		var pfpType string
-		if strings.HasPrefix(string(buf), "GIF") {
-			pfpType = "gif"
-		} else if strings.Contains(string(buf), "PNG") {
-			pfpType = "png"
-		} else {
-			pfpType = "jpg"
-		}
-		res, _ := util.GetDatabaseHandle().Query("UPDATE upload SET avatar= ? WHERE id= ?", base64.StdEncoding.EncodeToString(buf), client.Account.UserId)
-		res.Close()
-		res, _ = util.GetDatabaseHandle().Query("UPDATE myspace SET avatartype= ? WHERE id= ?", pfpType, client.Account.UserId)
-		res.Close()
-		buf = nil
-	}
 
-	util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
-		msim_new_data_boolean("persistr", true),
-		msim_new_data_int("uid", client.Account.UserId),
-		msim_new_data_int("cmd", cmd^256),
-		msim_new_data_string("dsn", dsn),
-		msim_new_data_string("lid", lid),
-		msim_new_data_string("rid", findValueFromKey("rid", packet)),
-		msim_new_data_dictonary("body", ""),
-	}))
+	accountRow, _ := global.GetUserDataFromUserId(parse)
+	accountData, _ := getMySpaceDataByUserId(parse)
+	privacy, _ := global.GetPrivacyFromUserId(parse)
+
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody([]msim_data_pair{
+		msim_new_data_string("UserName", accountRow.Email),
+		msim_new_data_int("UserID", accountRow.UserId),
+		msim_new_data_string("ImageURL", getAvatarUrl(client.Account.UserId, accountRow.UserId, accountData, privacy)),
+		msim_new_data_string("DisplayName", accountRow.Screenname),
+		msim_new_data_string("BandName", accountData.bandname),
+		msim_new_data_string("SongName", accountData.songname),
+		msim_new_data_int("Age", accountData.age),
+		msim_new_data_string("Gender", accountData.gender),
+		msim_new_data_string("Location", accountData.location),
+		msim_new_data_int(ctx.field("TotalFriends"), getFriendCount(accountRow.UserId)),
+	})))
 }
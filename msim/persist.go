@@ -0,0 +1,130 @@
+package msim
+
+import (
+	"phantom/global"
+	"phantom/util"
+	"strings"
+)
+
+/*
+	persist requests are addressed by cmd;dsn;lid
+
+	cmd 1   -> get
+	cmd 2   -> put
+	cmd 514 -> put (action)
+
+	replies xor the reply bit into cmd, errors additionally set the error bit
+*/
+
+const (
+	msim_cmd_get        = 1
+	msim_cmd_put        = 2
+	msim_cmd_bit_reply  = 256
+	msim_cmd_bit_action = 512
+	msim_cmd_bit_error  = 1024
+)
+
+type msim_persist_key struct {
+	cmd int
+	dsn int
+	lid int
+}
+
+type msim_persist_request struct {
+	cmd  int
+	dsn  int
+	lid  int
+	rid  string
+	uid  int
+	body msim_dictionary
+}
+
+type msim_persist_handler func(client *global.Client, ctx *msim_context, req *msim_persist_request)
+
+var persistHandlers = map[msim_persist_key]msim_persist_handler{
+	{msim_cmd_get, 0, 1}:                        handleClientPacketGetContactList,
+	{msim_cmd_get, 0, 2}:                        handleClientPacketGetContactInformation,
+	{msim_cmd_put, 0, 9}:                        handleClientPacketSetContactInformation,
+	{msim_cmd_bit_action | msim_cmd_put, 0, 9}:  handleClientPacketSetContactInformation,
+	{msim_cmd_get, 1, 4}:                        handleClientPacketUserLookupIMAboutMyself,
+	{msim_cmd_get, 1, 7}:                        handleClientPacketUserLookupIMByUid,
+	{msim_cmd_get, 1, 17}:                       handleClientPacketUserLookupIMByUid,
+	{msim_cmd_get, 2, 6}:                        handleClientPacketGetGroups,
+	{msim_cmd_get, 4, 3}:                        handleClientPacketUserLookupMySpaceByUid,
+	{msim_cmd_get, 4, 5}:                        handleClientPacketUserLookupMySpaceByUid,
+	{msim_cmd_get, 5, 7}:                        handleClientPacketUserLookupMySpaceByUsernameOrEmail,
+	{msim_cmd_get, 6, 11}:                       handleClientPacketRequestNetLink,
+	{msim_cmd_get, 7, 18}:                       handleClientPacketNewNotificationRequest,
+	{msim_cmd_put, 8, 13}:                       handleClientPacketChangePicture,
+	{msim_cmd_bit_action | msim_cmd_put, 8, 13}: handleClientPacketChangePicture,
+}
+
+// persist requests that only some builds are allowed to make
+var persistFeatures = map[msim_persist_key]int{
+	{msim_cmd_get, 6, 11}:                       msim_feature_netlinks,
+	{msim_cmd_get, 7, 18}:                       msim_feature_notifications,
+	{msim_cmd_put, 8, 13}:                       msim_feature_avatar_upload,
+	{msim_cmd_bit_action | msim_cmd_put, 8, 13}: msim_feature_avatar_upload,
+}
+
+func parsePersistRequest(packet *msim_message) *msim_persist_request {
+	return &msim_persist_request{
+		cmd:  packet.getInt("cmd"),
+		dsn:  packet.getInt("dsn"),
+		lid:  packet.getInt("lid"),
+		rid:  packet.get("rid"),
+		uid:  packet.getInt("uid"),
+		body: packet.getDictionary("body"),
+	}
+}
+
+func handleClientIncomingPersistPackets(client *global.Client, ctx *msim_context, packet *msim_message) {
+	if packet.get("persist") != "1" {
+		return
+	}
+
+	req := parsePersistRequest(packet)
+
+	key := msim_persist_key{req.cmd, req.dsn, req.lid}
+
+	if feature, gated := persistFeatures[key]; gated && !ctx.supports(feature) {
+		util.Debug("MySpace -> Persist", "Persist request %d;%d;%d is not available for %s clients", req.cmd, req.dsn, req.lid, client.Protocol)
+		handleClientPersistError(client, req, "This feature is not available in your version of MySpaceIM.")
+		return
+	}
+
+	handler, ok := persistHandlers[key]
+	if !ok {
+		util.Log("MySpace -> Persist", "Unhandled persist request %d;%d;%d from %s (rid: %s, body: %s)", req.cmd, req.dsn, req.lid, client.Account.Username, req.rid, strings.Replace(buildDataBody(req.body), "\x1c", "|", -1))
+		handleClientPersistError(client, req, "The requested operation is not supported.")
+		return
+	}
+
+	handler(client, ctx, req)
+}
+
+func buildPersistReply(client *global.Client, req *msim_persist_request, body string) string {
+	return buildDataPacket([]msim_data_pair{
+		msim_new_data_boolean("persistr", true),
+		msim_new_data_int("uid", client.Account.UserId),
+		msim_new_data_int("cmd", req.cmd^msim_cmd_bit_reply),
+		msim_new_data_int("dsn", req.dsn),
+		msim_new_data_int("lid", req.lid),
+		msim_new_data_string("rid", req.rid),
+		msim_new_data_dictonary("body", body),
+	})
+}
+
+func handleClientPersistError(client *global.Client, req *msim_persist_request, errmsg string) {
+	util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_boolean("persistr", true),
+		msim_new_data_int("uid", client.Account.UserId),
+		msim_new_data_int("cmd", req.cmd^msim_cmd_bit_reply|msim_cmd_bit_error),
+		msim_new_data_int("dsn", req.dsn),
+		msim_new_data_int("lid", req.lid),
+		msim_new_data_string("rid", req.rid),
+		msim_new_data_dictonary("body", buildDataBody([]msim_data_pair{
+			msim_new_data_string("ErrorMessage", errmsg),
+		})),
+	}))
+}
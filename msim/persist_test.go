@@ -0,0 +1,104 @@
+package msim
+
+import (
+	"net"
+	"phantom/global"
+	"testing"
+	"time"
+)
+
+// runs the router on one end of a pipe and returns the packet it wrote, "" when it wrote nothing
+func routePersist(t *testing.T, ctx *msim_context, packet string) string {
+	t.Helper()
+
+	server, remote := net.Pipe()
+	defer server.Close()
+	defer remote.Close()
+
+	client := &global.Client{Connection: server, Account: global.Account{UserId: 1}}
+	ctx.client = client
+
+	msg, ok := parseDataPacket([]byte(packet))
+	if !ok {
+		t.Fatalf("failed to parse %q", packet)
+	}
+
+	go func() {
+		handleClientIncomingPersistPackets(client, ctx, msg)
+		server.Close()
+	}()
+
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, _ := remote.Read(buf)
+	return string(buf[:n])
+}
+
+func TestParsePersistRequest(t *testing.T) {
+	msg, _ := parseDataPacket([]byte("\\persist\\1\\sesskey\\1\\cmd\\514\\dsn\\0\\uid\\7\\lid\\9\\rid\\4\\body\\ContactID=2\x1cNickName=x\\final\\"))
+
+	req := parsePersistRequest(msg)
+	if req.cmd != msim_cmd_bit_action|msim_cmd_put || req.dsn != 0 || req.lid != 9 || req.rid != "4" || req.uid != 7 {
+		t.Errorf("request = %+v", req)
+	}
+	if req.body.get("NickName") != "x" {
+		t.Errorf("body = %q", req.body)
+	}
+}
+
+func TestPersistRouting(t *testing.T) {
+	tests := []struct {
+		name   string
+		ctx    msim_context
+		packet string
+		cmd    int
+		err    string
+	}{
+		{
+			name:   "unknown request",
+			packet: "\\persist\\1\\sesskey\\1\\cmd\\1\\dsn\\99\\uid\\1\\lid\\99\\rid\\3\\body\\\\final\\",
+			cmd:    msim_cmd_get | msim_cmd_bit_reply | msim_cmd_bit_error,
+			err:    "The requested operation is not supported.",
+		},
+		{
+			name:   "feature missing from the build",
+			packet: "\\persist\\1\\sesskey\\1\\cmd\\1\\dsn\\6\\uid\\1\\lid\\11\\rid\\5\\body\\\\final\\",
+			cmd:    msim_cmd_get | msim_cmd_bit_reply | msim_cmd_bit_error,
+			err:    "This feature is not available in your version of MySpaceIM.",
+		},
+		{
+			name:   "not a persist packet",
+			packet: "\\persist\\0\\sesskey\\1\\cmd\\1\\dsn\\0\\uid\\1\\lid\\1\\rid\\6\\final\\",
+		},
+	}
+
+	for _, test := range tests {
+		reply := routePersist(t, &test.ctx, test.packet)
+		if test.cmd == 0 {
+			if reply != "" {
+				t.Errorf("%s: unexpected reply %q", test.name, reply)
+			}
+			continue
+		}
+
+		msg, ok := parseDataPacket([]byte(reply))
+		if !ok {
+			t.Errorf("%s: reply %q did not parse", test.name, reply)
+			continue
+		}
+		if msg.getInt("cmd") != test.cmd {
+			t.Errorf("%s: cmd = %d, want %d", test.name, msg.getInt("cmd"), test.cmd)
+		}
+		if got := msg.getDictionary("body").get("ErrorMessage"); got != test.err {
+			t.Errorf("%s: error = %q, want %q", test.name, got, test.err)
+		}
+	}
+}
+
+func TestPersistFeaturesHaveHandlers(t *testing.T) {
+	for key := range persistFeatures {
+		if _, ok := persistHandlers[key]; !ok {
+			t.Errorf("gated request %d;%d;%d has no handler", key.cmd, key.dsn, key.lid)
+		}
+	}
+}
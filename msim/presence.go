@@ -0,0 +1,164 @@
+package msim
+
+import (
+	"fmt"
+	"phantom/util"
+	"time"
+)
+
+/*
+	msim status codes
+
+	0 -> offline (or invisible)
+	1 -> online
+	2 -> idle
+	5 -> away
+*/
+
+const (
+	msim_status_offline = 0
+	msim_status_online  = 1
+	msim_status_idle    = 2
+	msim_status_away    = 5
+)
+
+type msim_presence struct {
+	statuscode    int
+	statusmessage string
+	awaymessage   string
+	idlesince     int64
+	lastchange    int64
+}
+
+func getPresenceByUserId(uid int) msim_presence {
+	var presence msim_presence
+
+	row, err := util.GetDatabaseHandle().Query("SELECT status, statustext, awaymsg, idlesince, lastchange from presence WHERE id= ?", uid)
+	if err != nil {
+		util.Error("MySpace -> getPresenceByUserId", err.Error())
+		return presence
+	}
+
+	if row.Next() {
+		row.Scan(&presence.statuscode, &presence.statusmessage, &presence.awaymessage, &presence.idlesince, &presence.lastchange)
+	}
+	row.Close()
+
+	return presence
+}
+
+func storePresence(uid int, presence msim_presence) {
+	presence.lastchange = time.Now().UTC().UnixMilli()
+
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO presence (`id`, `status`, `statustext`, `awaymsg`, `idlesince`, `lastchange`) VALUES (?, ?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE status= VALUES(status), statustext= VALUES(statustext), awaymsg= VALUES(awaymsg), idlesince= VALUES(idlesince), lastchange= VALUES(lastchange)",
+		uid, presence.statuscode, presence.statusmessage, presence.awaymessage, presence.idlesince, presence.lastchange)
+	if err != nil {
+		util.Error("MySpace -> storePresence", err.Error())
+		return
+	}
+	res.Close()
+}
+
+// mutual contacts are the only ones who get to see each other's status
+func getMutualContacts(uid int) []int {
+	var contacts []int
+
+	res, err := util.GetDatabaseHandle().Query("SELECT a.to_id from contacts a INNER JOIN contacts b ON b.from_id = a.to_id AND b.to_id = a.from_id WHERE a.from_id= ?", uid)
+	if err != nil {
+		util.Error("MySpace -> getMutualContacts", err.Error())
+		return contacts
+	}
+
+	for res.Next() {
+		var id int
+		res.Scan(&id)
+		contacts = append(contacts, id)
+	}
+	res.Close()
+
+	return contacts
+}
+
+// the text is a list item, so a | in it would shift the fields. / and \ are escaped with the packet
+func buildStatusString(statuscode int, statusmessage string) string {
+	return fmt.Sprintf("|s|%d|ss|%s", statuscode, escapeValue(statusmessage, "|"))
+}
+
+// what contacts see of a session that is offline, or that they can no longer see
+func (ctx *msim_context) offlineStatus() string {
+	return buildStatusString(msim_status_offline, ctx.awaymessage)
+}
+
+// what contacts see, sessions that went offline show their away message
+// idle sessions add |it|<seconds idle>, counted when the update is sent so late arrivals get the current value
+func (ctx *msim_context) visibleStatus() string {
+	if ctx.statuscode == msim_status_offline {
+		return ctx.offlineStatus()
+	}
+
+	status := buildStatusString(ctx.statuscode, ctx.statusmessage)
+	if ctx.statuscode == msim_status_idle && ctx.idlesince > 0 {
+		status += fmt.Sprintf("|it|%d", ctx.idleSeconds())
+	}
+	return status
+}
+
+func (ctx *msim_context) idleSeconds() int64 {
+	idle := (time.Now().UTC().UnixMilli() - ctx.idlesince) / 1000
+	if idle < 0 {
+		return 0
+	}
+	return idle
+}
+
+func sendStatusUpdate(to *msim_context, from int, status string) {
+	util.WriteTraffic(to.client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_int("bm", 100),
+		msim_new_data_int("f", from),
+		msim_new_data_string("msg", status),
+	}))
+}
+
+// sends the session's status to every online mutual contact
+func handleClientBroadcastStatus(ctx *msim_context) {
+	contacts := getMutualContacts(ctx.client.Account.UserId)
+	for i := 0; i < len(contacts); i++ {
+		if contact := getUserContext(contacts[i]); contact != nil {
+			sendStatusUpdate(contact, ctx.client.Account.UserId, ctx.visibleStatus())
+		}
+	}
+}
+
+func (ctx *msim_context) loadPresence() {
+	presence := getPresenceByUserId(ctx.client.Account.UserId)
+	ctx.statuscode = msim_status_online
+	ctx.statusmessage = presence.statusmessage
+	ctx.awaymessage = presence.awaymessage
+	ctx.idlesince = 0
+}
+
+func (ctx *msim_context) storePresence() {
+	storePresence(ctx.client.Account.UserId, msim_presence{
+		statuscode:    ctx.statuscode,
+		statusmessage: ctx.statusmessage,
+		awaymessage:   ctx.awaymessage,
+		idlesince:     ctx.idlesince,
+	})
+}
+
+func (ctx *msim_context) setStatus(statuscode int, statusmessage string) {
+	if statuscode == msim_status_idle {
+		if ctx.idlesince == 0 {
+			ctx.idlesince = time.Now().UTC().UnixMilli()
+		}
+	} else {
+		ctx.idlesince = 0
+	}
+
+	if statuscode == msim_status_away && statusmessage != "" {
+		ctx.awaymessage = statusmessage
+	}
+
+	ctx.statuscode = statuscode
+	ctx.statusmessage = statusmessage
+}
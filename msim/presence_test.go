@@ -0,0 +1,28 @@
+package msim
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVisibleStatus(t *testing.T) {
+	idlesince := time.Now().UTC().Add(-90 * time.Second).UnixMilli()
+
+	tests := []struct {
+		name string
+		ctx  msim_context
+		want string
+	}{
+		{"online", msim_context{statuscode: msim_status_online, statusmessage: "hi"}, "|s|1|ss|hi"},
+		{"pipe in text", msim_context{statuscode: msim_status_online, statusmessage: "a|b"}, "|s|1|ss|a b"},
+		{"offline shows away message", msim_context{statuscode: msim_status_offline, statusmessage: "hi", awaymessage: "brb"}, "|s|0|ss|brb"},
+		{"idle carries idle time", msim_context{statuscode: msim_status_idle, idlesince: idlesince}, "|s|2|ss||it|9"},
+	}
+
+	for _, test := range tests {
+		if got := test.ctx.visibleStatus(); !strings.HasPrefix(got, test.want) {
+			t.Errorf("%s: status = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package msim
+
+import (
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	msim_search_refresh   = time.Minute
+	msim_search_page_size = 10
+)
+
+type msim_search_entry struct {
+	userid     int
+	email      string
+	username   string
+	screenname string
+	location   string
+	gender     string
+	age        int
+	avatartype string
+	bandname   string
+	songname   string
+	searchable bool
+	privacy    global.Privacy
+}
+
+type msim_search_query struct {
+	name     string
+	location string
+	gender   string
+	minage   int
+	maxage   int
+	page     int
+}
+
+type msim_search_index struct {
+	sync.Mutex
+	entries []msim_search_entry
+	built   time.Time
+}
+
+var search_index msim_search_index
+
+// rebuilds the index from the database once it went stale
+func (index *msim_search_index) refresh() {
+	if time.Since(index.built) < msim_search_refresh {
+		return
+	}
+
+	res, err := util.GetDatabaseHandle().Query("SELECT a.id, a.email, a.screenname, m.location, m.gender, m.age, m.avatartype, m.bandname, m.songname, COALESCE(p.searchable, 1), COALESCE(p.privacymode, 0), COALESCE(p.showavatar, 1) from accounts a INNER JOIN myspace m ON m.id = a.id LEFT JOIN privacy p ON p.id = a.id")
+	if err != nil {
+		util.Error("MySpace -> refreshSearchIndex", err.Error())
+		return
+	}
+
+	var entries []msim_search_entry
+	for res.Next() {
+		var entry msim_search_entry
+		res.Scan(&entry.userid, &entry.email, &entry.screenname, &entry.location, &entry.gender, &entry.age, &entry.avatartype, &entry.bandname, &entry.songname, &entry.searchable, &entry.privacy.PrivacyMode, &entry.privacy.ShowAvatar)
+		entry.privacy.UserId = entry.userid
+		entry.privacy.Searchable = entry.searchable
+		entry.username = strings.Replace(entry.email, util.GetMailDomain(), "", -1)
+		entries = append(entries, entry)
+	}
+	res.Close()
+
+	index.entries = entries
+	index.built = time.Now()
+	util.Debug("MySpace -> refreshSearchIndex", "Indexed %d users", len(entries))
+}
+
+// users who opted out of search are hidden here as well, otherwise their address would give them away
+func (index *msim_search_index) lookup(username string, email string) (msim_search_entry, bool) {
+	index.Lock()
+	defer index.Unlock()
+	index.refresh()
+
+	for i := 0; i < len(index.entries); i++ {
+		if !index.entries[i].searchable {
+			continue
+		}
+		if (username != "" && strings.EqualFold(index.entries[i].username, username)) || (email != "" && strings.EqualFold(index.entries[i].email, email)) {
+			return index.entries[i], true
+		}
+	}
+
+	return msim_search_entry{}, false
+}
+
+func (index *msim_search_index) search(query msim_search_query) []msim_search_entry {
+	index.Lock()
+	defer index.Unlock()
+	index.refresh()
+
+	name := strings.ToLower(query.name)
+	location := strings.ToLower(query.location)
+
+	var results []msim_search_entry
+	for i := 0; i < len(index.entries); i++ {
+		entry := index.entries[i]
+
+		if !entry.searchable {
+			continue
+		}
+		if name != "" && !strings.Contains(strings.ToLower(entry.screenname), name) && !strings.Contains(strings.ToLower(entry.username), name) {
+			continue
+		}
+		if location != "" && !strings.Contains(strings.ToLower(entry.location), location) {
+			continue
+		}
+		if query.gender != "" && !strings.EqualFold(entry.gender, query.gender) {
+			continue
+		}
+		if (query.minage > 0 || query.maxage > 0) && entry.age <= 0 {
+			continue
+		}
+		if query.minage > 0 && entry.age < query.minage {
+			continue
+		}
+		if query.maxage > 0 && entry.age > query.maxage {
+			continue
+		}
+
+		results = append(results, entry)
+	}
+
+	return results
+}
+
+func parseSearchQuery(body msim_dictionary) msim_search_query {
+	query := msim_search_query{
+		name:     body.get("DisplayName"),
+		location: body.get("Location"),
+		gender:   body.get("Gender"),
+	}
+
+	query.minage, _ = strconv.Atoi(body.get("MinAge"))
+	query.maxage, _ = strconv.Atoi(body.get("MaxAge"))
+	query.page, _ = strconv.Atoi(body.get("Page"))
+
+	return query
+}
+
+func buildSearchResult(viewer int, entry msim_search_entry) []msim_data_pair {
+	return []msim_data_pair{
+		msim_new_data_string("UserName", entry.email),
+		msim_new_data_int("UserID", entry.userid),
+		msim_new_data_string("ImageURL", getAvatarUrl(viewer, entry.userid, msim_user_details{avatartype: entry.avatartype}, entry.privacy)),
+		msim_new_data_string("DisplayName", entry.screenname),
+		msim_new_data_string("BandName", entry.bandname),
+		msim_new_data_string("SongName", entry.songname),
+		msim_new_data_int("Age", entry.age),
+		msim_new_data_string("Gender", entry.gender),
+		msim_new_data_string("Location", entry.location),
+	}
+}
+
+// Persist 1;5;7
+func handleClientPacketUserLookupMySpaceByUsernameOrEmail(client *global.Client, ctx *msim_context, req *msim_persist_request) {
+	username := req.body.get("UserName")
+	email := req.body.get("Email")
+
+	if username != "" || email != "" {
+		entry, ok := search_index.lookup(username, email)
+		if !ok {
+			handleClientPersistError(client, req, "No user was found.")
+			return
+		}
+
+		util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody(buildSearchResult(client.Account.UserId, entry))))
+		return
+	}
+
+	query := parseSearchQuery(req.body)
+	results := search_index.search(query)
+
+	pages := (len(results) + msim_search_page_size - 1) / msim_search_page_size
+	if query.page < 0 || (pages > 0 && query.page >= pages) {
+		handleClientPersistError(client, req, "The requested page does not exist.")
+		return
+	}
+
+	start := query.page * msim_search_page_size
+	end := start + msim_search_page_size
+	if end > len(results) {
+		end = len(results)
+	}
+
+	body := []msim_data_pair{
+		msim_new_data_int("TotalResults", len(results)),
+		msim_new_data_int("Page", query.page),
+		msim_new_data_int("PageCount", pages),
+	}
+	for i := start; i < end; i++ {
+		body = append(body, buildSearchResult(client.Account.UserId, results[i])...)
+	}
+
+	util.Debug("MySpace -> handleClientPacketUserLookupMySpaceByUsernameOrEmail", "Search by %s matched %d users", client.Account.Username, len(results))
+	util.WriteTraffic(client.Connection, buildPersistReply(client, req, buildDataBody(body)))
+}
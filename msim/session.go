@@ -0,0 +1,40 @@
+package msim
+
+import (
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+)
+
+const (
+	msim_max_session_violations = 3
+	msim_error_invalid_session  = 6
+)
+
+// checks that a post-login packet belongs to this session and its user
+func handleClientValidateSession(client *global.Client, ctx *msim_context, msg *msim_message) bool {
+	errmsg := ""
+
+	if sesskey, err := strconv.Atoi(msg.get("sesskey")); err != nil || sesskey != ctx.sesskey {
+		errmsg = "The session key is invalid."
+	} else if msg.has("uid") && msg.getInt("uid") != client.Account.UserId {
+		errmsg = "The user id does not match the session."
+	}
+
+	if errmsg == "" {
+		return true
+	}
+
+	ctx.violations++
+	util.Log("MySpaceIM", "Rejected %s packet from %s: %s (%d/%d)", msg.command(), client.Account.Username, errmsg, ctx.violations, msim_max_session_violations)
+
+	fatal := ctx.violations >= msim_max_session_violations
+	util.WriteTraffic(client.Connection, buildDataPacket([]msim_data_pair{
+		msim_new_data_boolean("error", true),
+		msim_new_data_string("errmsg", errmsg),
+		msim_new_data_int("err", msim_error_invalid_session),
+		msim_new_data_boolean("fatal", fatal),
+	}))
+
+	return false
+}
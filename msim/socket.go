@@ -1,11 +1,14 @@
 package msim
 
 import (
+	"bytes"
 	"phantom/global"
 	"phantom/util"
-	"strings"
 )
 
+// largest unfinished packet kept around, picture chunks are the biggest packets clients send
+const msim_max_pending = 256 * 1024
+
 func HandleClients(client *global.Client) {
 	util.Log("MySpaceIM", "Client awaiting authentication from %s", client.Connection.RemoteAddr().String())
 
@@ -24,24 +27,51 @@ func HandleClients(client *global.Client) {
 	}
 
 	global.AddClient(client)
+	ctx.client = client
 
 	handleClientBroadcastSignOnStatus(client, &ctx)
 	handleClientHandleOfflineMessages(client, &ctx)
+	handleClientHandleOfflineReceipts(client, &ctx)
+	handleClientHandleBulletins(client, &ctx)
 
+	var pending []byte
 	for {
 		data, success := util.ReadTraffic(client.Connection)
+		pending = append(pending, bytes.TrimRight(data, "\x00")...)
+
+		//split packets that get sent simultaneously or across reads
+		var receivedpackets [][]byte
+		receivedpackets, pending = splitDataPackets(pending)
 
-		//split packets that get sent simultaneously
-		receivedpackets := strings.Split(string(data), "final\\")
+		// a client that never finishes its packet would otherwise grow the buffer forever
+		if len(pending) > msim_max_pending {
+			util.Log("MySpaceIM", "Client exceeded the packet size limit -> Username: %s", client.Account.Username)
+			break
+		}
+
+		disconnect := false
 		for i := 0; i < len(receivedpackets); i++ {
-			if strings.Contains(receivedpackets[i], "\\") {
-				util.Debug("MySpace -> HandleClients -> TCP", "Reading Split Data: %s", string(receivedpackets[i]+"final\\"))
-				handleClientIncomingPackets(client, &ctx, []byte(receivedpackets[i]+"final\\"))
-				handleClientIncomingPersistPackets(client, &ctx, []byte(receivedpackets[i]+"final\\"))
+			util.Debug("MySpace -> HandleClients -> TCP", "Reading Split Data: %s", string(receivedpackets[i]))
+			msg, ok := parseDataPacket(receivedpackets[i])
+			if !ok {
+				util.Debug("MySpace -> HandleClients", "Dropping malformed packet")
+				continue
+			}
+			if !handleClientValidateSession(client, &ctx, msg) {
+				if ctx.violations >= msim_max_session_violations {
+					disconnect = true
+					break
+				}
+				continue
+			}
+			if handleClientLogoutRequest(msg) {
+				disconnect = true
+				break
 			}
+			handleClientIncomingPackets(client, &ctx, msg)
 		}
 
-		if !success || handleClientLogoutRequest(string(data)) {
+		if !success || disconnect {
 			break
 		}
 	}
@@ -50,12 +80,14 @@ func HandleClients(client *global.Client) {
 
 	util.Log("MySpaceIM", "Client Disconnected -> Username: %s", client.Account.Username)
 
+	global.ClientsLock.Lock()
 	for i := 0; i < len(global.Clients); i++ {
 		if global.Clients[i].Account.Email == client.Account.Email {
 			util.Debug("MySpace -> HandleClients", "Removing from clients from Client List...")
 			global.Clients = global.RemoveClient(global.Clients, i)
 		}
 	}
+	global.ClientsLock.Unlock()
 
 	for ix := 0; ix < len(users_context); ix++ {
 		if users_context[ix].sesskey == ctx.sesskey {
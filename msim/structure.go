@@ -1,15 +1,36 @@
 package msim
 
+import (
+	"phantom/global"
+	"time"
+)
+
 type msim_data_pair struct {
 	Key   string
 	Value string
+	Type  int
 }
 
+type msim_dictionary []msim_data_pair
+
 type msim_context struct {
+	client        *global.Client
 	nonce         string
 	sesskey       int
 	statuscode    int
 	statusmessage string
+	awaymessage   string
+	idlesince     int64
+	upload        *msim_avatar_upload
+	violations    int
+	build         *msim_client_build
+	lastzap       map[int]time.Time
+}
+
+type msim_avatar_upload struct {
+	data      []byte
+	chunks    int
+	lastchunk time.Time
 }
 
 type msim_user_details struct {
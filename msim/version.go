@@ -0,0 +1,73 @@
+package msim
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+	client builds and what they understand
+
+	bang fields    -> body fields prefixed with ! (e.g. !ShowAvatar)
+	avatar upload  -> persist 8;13 picture uploads
+	netlinks       -> persist 6;11 web links
+	notifications  -> persist 7;18 notification pane
+	zaps           -> !!!ZAP_SEND!!! action messages
+*/
+
+const (
+	msim_feature_bang_fields = 1 << iota
+	msim_feature_avatar_upload
+	msim_feature_netlinks
+	msim_feature_notifications
+	msim_feature_zaps
+)
+
+type msim_client_build struct {
+	protocol string
+	minbuild int
+	maxbuild int
+	features int
+}
+
+var msim_client_builds = []msim_client_build{
+	{"MSIMv1", 1, 253, 0},
+	{"MSIMv2", 254, 365, msim_feature_zaps},
+	{"MSIMv3", 366, 403, msim_feature_zaps | msim_feature_netlinks},
+	{"MSIMv4", 404, 593, msim_feature_zaps | msim_feature_netlinks | msim_feature_bang_fields},
+	{"MSIMv5", 594, 672, msim_feature_zaps | msim_feature_netlinks | msim_feature_bang_fields | msim_feature_avatar_upload},
+	{"MSIMv6", 673, 696, msim_feature_zaps | msim_feature_netlinks | msim_feature_bang_fields | msim_feature_avatar_upload | msim_feature_notifications},
+	{"MSIMv7", 697, 811, msim_feature_zaps | msim_feature_netlinks | msim_feature_bang_fields | msim_feature_avatar_upload | msim_feature_notifications},
+}
+
+func identifyClientBuild(clientver string) (*msim_client_build, bool) {
+	ver, err := strconv.Atoi(clientver)
+	if err != nil {
+		return nil, false
+	}
+
+	for i := 0; i < len(msim_client_builds); i++ {
+		if ver >= msim_client_builds[i].minbuild && ver <= msim_client_builds[i].maxbuild {
+			return &msim_client_builds[i], true
+		}
+	}
+
+	return nil, false
+}
+
+func getUpgradeMessage() string {
+	latest := msim_client_builds[len(msim_client_builds)-1]
+	return fmt.Sprintf("This version of MySpaceIM is not supported. Please upgrade to a build between 1.0.%d.0 and 1.0.%d.0.", msim_client_builds[0].minbuild, latest.maxbuild)
+}
+
+func (ctx *msim_context) supports(feature int) bool {
+	return ctx.build != nil && ctx.build.features&feature != 0
+}
+
+// older builds don't know the ! prefix on body fields
+func (ctx *msim_context) field(name string) string {
+	if ctx.supports(msim_feature_bang_fields) {
+		return "!" + name
+	}
+	return name
+}
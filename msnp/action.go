@@ -0,0 +1,186 @@
+package msnp
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var markupTags = regexp.MustCompile("<[^>]*>")
+
+// messages kept for a target that hasn't answered the RNG yet
+const msnp_bridge_queue = 20
+
+// serializes opening bridged sessions, so one sender doesn't ring the same target twice
+var bridgeLock sync.Mutex
+
+func init() {
+	global.RegisterActionTranslator("MSN Messenger", handleTranslateAction)
+}
+
+// turns events from other protocols into switchboard MSG payloads
+func translateAction(event global.ActionEvent) (string, bool) {
+	switch event.Kind {
+	case global.ActionText:
+		return newTextMessage(html.UnescapeString(markupTags.ReplaceAllString(event.Text, ""))).build(), true
+	case global.ActionTyping:
+		return newTypingMessage(event.From.Email).build(), true
+	case global.ActionZap:
+		return newTextMessage(event.Text).build(), true
+	}
+
+	return "", false
+}
+
+// users of other protocols get their own switchboard session with the target, nobody else's conversation is touched
+func handleTranslateAction(target *global.Client, event global.ActionEvent) bool {
+	// there is nothing to stop on msnp, the typing notification just times out
+	if event.Kind == global.ActionStopTyping {
+		return true
+	}
+
+	payload, ok := translateAction(event)
+	if !ok {
+		return false
+	}
+	line := fmt.Sprintf("MSG %s %s %d\r\n%s", event.From.Email, url.PathEscape(event.From.Screenname), len(payload), payload)
+
+	bridgeLock.Lock()
+	defer bridgeLock.Unlock()
+
+	switchboardLock.Lock()
+	bridge := getBridgeContext(event.From.UserId, target.Account.Email)
+	switchboardLock.Unlock()
+
+	if bridge == nil {
+		// typing alone doesn't warrant ringing somebody
+		if event.Kind == global.ActionTyping {
+			return false
+		}
+
+		bridge = openBridgeSession(target, event.From)
+		if bridge == nil {
+			return false
+		}
+	}
+
+	switchboardLock.Lock()
+	joined := hasConnectedParticipants(bridge.session)
+	if !joined {
+		// held until the target answers the RNG, see handleClientSwitchboardPacketAuthentication
+		if len(bridge.queued) >= msnp_bridge_queue {
+			switchboardLock.Unlock()
+			return false
+		}
+		bridge.queued = append(bridge.queued, line)
+	}
+	switchboardLock.Unlock()
+
+	if !joined {
+		return true
+	}
+
+	return broadcastSwitchboard(bridge, line)
+}
+
+// switchboardLock has to be held
+func getBridgeContext(uid int, email string) *msnp_switchboard_context {
+	for i := 0; i < len(msn_switchboard_sessions); i++ {
+		clients := msn_switchboard_sessions[i].clients
+		for ix := 0; ix < len(clients); ix++ {
+			if clients[ix].bridged && clients[ix].userid == uid && clients[ix].bridgeto == email {
+				return clients[ix]
+			}
+		}
+	}
+	return nil
+}
+
+// starts a session owned by the bridged sender and rings the target into it
+func openBridgeSession(target *global.Client, from global.Account) *msnp_switchboard_context {
+	// the target's privacy settings apply just like for CAL
+	if visibleState(getNotificationContext(target.Account.Email), target.Account.UserId, from.UserId) == "" {
+		return nil
+	}
+
+	bridge := &msnp_switchboard_context{
+		username: from.Screenname,
+		email:    from.Email,
+		created:  time.Now(),
+		bridged:  true,
+		userid:   from.UserId,
+		bridgeto: target.Account.Email,
+	}
+	createSwitchboardSession(bridge)
+
+	if ringSwitchboard(bridge.sessionid, target, from.Email, from.Screenname) != nil {
+		switchboardLock.Lock()
+		for i := 0; i < len(msn_switchboard_sessions); i++ {
+			if msn_switchboard_sessions[i] == bridge.session {
+				msn_switchboard_sessions = removeSwitchboardSession(msn_switchboard_sessions, i)
+				break
+			}
+		}
+		switchboardLock.Unlock()
+		return nil
+	}
+
+	util.Debug("MSNP -> openBridgeSession", "Ringing %s into session %d for %s", target.Account.Email, bridge.sessionid, from.Email)
+	return bridge
+}
+
+// switchboard messages the other protocols understand, ok is false for everything else
+func actionFromMessage(msg *msnp_mime_message) (global.ActionEvent, bool) {
+	switch msg.kind() {
+	case msnp_message_text:
+		return global.ActionEvent{Kind: global.ActionText, Text: msg.body}, true
+	case msnp_message_typing:
+		return global.ActionEvent{Kind: global.ActionTyping}, true
+	}
+	return global.ActionEvent{}, false
+}
+
+// hands a message to the other protocol of every bridged participant, false when one of them couldn't take it
+func deliverBridgedMessage(ctx *msnp_switchboard_context, msg *msnp_mime_message) bool {
+	participants := getSwitchboardParticipants(ctx)
+
+	delivered := true
+	for i := 0; i < len(participants); i++ {
+		if !participants[i].bridged {
+			continue
+		}
+
+		event, ok := actionFromMessage(msg)
+		target := getBridgedClient(participants[i].userid)
+		if !ok || target == nil {
+			delivered = false
+			continue
+		}
+
+		from, _ := global.GetUserDataFromEmail(ctx.email)
+		event.From = from
+		event.ToId = participants[i].userid
+
+		if !global.DeliverAction(target, event) {
+			delivered = false
+		}
+	}
+
+	return delivered
+}
+
+// the connection a bridged participant is actually using
+func getBridgedClient(uid int) *global.Client {
+	clients := global.SnapshotClients()
+	for i := 0; i < len(clients); i++ {
+		if clients[i].Client != "MSN Messenger" && clients[i].Account.UserId == uid {
+			return clients[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package msnp
+
+import (
+	"fmt"
+	"math/rand"
+	"phantom/global"
+	"phantom/util"
+	"strings"
+)
+
+/*
+	MSNP6+ clients have to answer CHL 0 <challenge> with
+	QRY <trid> <client id> 32\r\n<md5(challenge + client key)>
+
+	the table can be extended with "msnpclients" in config.json
+*/
+
+var msnp_default_client_keys = map[string]string{
+	"msmsgs@msnmsgr.com": "Q1P7W2E4J9R8U3S5",
+	"PROD0038W!61ZTF9":   "VT6PX?UQTM4WM%YR",
+}
+
+func getClientKey(clientid string) (string, bool) {
+	if key, ok := util.GetMSNPClientKeys()[clientid]; ok {
+		return key, true
+	}
+
+	key, ok := msnp_default_client_keys[clientid]
+	return key, ok
+}
+
+func generateChallenge() string {
+	return fmt.Sprintf("%d%d", 10000000000+rand.Int63n(89999999999), 100000000+rand.Int63n(899999999))
+}
+
+func sendChallenge(client *global.Client, ctx *msnp_context) {
+	ctx.challenge = generateChallenge()
+	util.WriteTraffic(client.Connection, fmt.Sprintf("CHL 0 %s\r\n", ctx.challenge))
+}
+
+// QRY <trid> <client id> <length>\r\n<response>, a wrong answer ends the session
+func handleClientPacketChallengeResponse(client *global.Client, ctx *msnp_context, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_challenge) {
+		return
+	}
+
+	header, payload := splitPayload(data)
+	key, ok := getClientKey(findValueFromData("QRY", header, 1))
+
+	if ctx.challenge == "" || !ok || !strings.EqualFold(strings.TrimSpace(payload), util.HashMD5(ctx.challenge+key)) {
+		util.Log("MSN Messenger", "Challenge failed -> Email: %s, Client ID: %s", client.Account.Email, findValueFromData("QRY", header, 1))
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(header, "540"))
+		client.Connection.Close()
+		return
+	}
+
+	ctx.challenge = ""
+	util.WriteTraffic(client.Connection, msnp_new_command_noargs(header, "QRY"))
+}
@@ -0,0 +1,37 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+)
+
+/*
+	per-owner contact metadata, shared with MSIM through global.ContactInfo
+
+	msnp only knows the nickname: it replaces the contact's friendly name in the owner's FL
+	and is set with REA on the contact. notes and the name-select preference stay MSIM only
+*/
+
+// FL entries carry the owner's nickname for the contact when there is one
+const msnp_forward_list_query = "SELECT a.id, a.email, COALESCE(NULLIF(i.nickname, ''), a.screenname) from contacts c INNER JOIN accounts a ON a.id = c.to_id LEFT JOIN contactinfo i ON i.owner_id = c.from_id AND i.contact_id = c.to_id WHERE c.from_id= ?"
+
+// REA <trid> <email> <nick> for a contact on the FL, only the owner's view of them changes
+func handleClientPacketRenameContact(client *global.Client, data string, mail string, name string) {
+	acc, _ := global.GetUserDataFromEmail(mail)
+	if acc.UserId == 0 || !isOnList(client.Account.UserId, acc.UserId, msnp_list_forward) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "216"))
+		return
+	}
+
+	info, _ := global.GetContactInfo(client.Account.UserId, acc.UserId)
+	info.NickName = name
+	if !global.SetContactInfo(info) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+		return
+	}
+
+	version := global.IncrementListVersion(client.Account.UserId)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "REA", fmt.Sprintf("%d %s %s", version, acc.Email, url.PathEscape(name))))
+}
@@ -0,0 +1,111 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	msnp_search_max_results = 100
+	msnp_search_limit       = 5
+	msnp_search_window      = time.Minute
+)
+
+// user id -> times of their recent searches
+var msnp_searches = map[int][]time.Time{}
+var searchLock sync.Mutex
+
+// allows msnp_search_limit searches per user within msnp_search_window, reconnecting does not reset it
+func allowDirectorySearch(uid int) bool {
+	searchLock.Lock()
+	defer searchLock.Unlock()
+
+	var recent []time.Time
+	for _, at := range msnp_searches[uid] {
+		if time.Since(at) < msnp_search_window {
+			recent = append(recent, at)
+		}
+	}
+
+	if len(recent) >= msnp_search_limit {
+		msnp_searches[uid] = recent
+		return false
+	}
+
+	msnp_searches[uid] = append(recent, time.Now())
+	return true
+}
+
+// FND values are url encoded, a * leaves the field out of the search
+func parseDirectoryCriteria(data string) global.DirectoryEntry {
+	var criteria global.DirectoryEntry
+
+	splits := strings.Fields(data)
+	for ix := 2; ix < len(splits); ix++ {
+		key, value, found := strings.Cut(splits[ix], "=")
+		if !found || value == "*" {
+			continue
+		}
+
+		if decoded, err := url.PathUnescape(value); err == nil {
+			value = decoded
+		}
+
+		switch strings.ToLower(key) {
+		case "fname":
+			criteria.FirstName = value
+		case "lname":
+			criteria.LastName = value
+		case "city":
+			criteria.City = value
+		case "state":
+			criteria.State = value
+		case "country":
+			criteria.Country = strings.ToUpper(value)
+		}
+	}
+
+	return criteria
+}
+
+func encodeDirectoryValue(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return url.PathEscape(value)
+}
+
+// FND <trid> fname=<first> lname=<last> city=<city> state=<state> country=<country>
+// every result is its own FND line carrying index and total, no results is FND <trid> 0 0
+func handleClientPacketDirectorySearch(client *global.Client, data string) {
+	criteria := parseDirectoryCriteria(data)
+
+	// a name is required so the directory can't be dumped with wildcards
+	if criteria.FirstName == "" && criteria.LastName == "" {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "300"))
+		return
+	}
+
+	if !allowDirectorySearch(client.Account.UserId) {
+		util.Debug("MSNP -> handleClientPacketDirectorySearch", "%s is searching too often", client.Account.Email)
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "713"))
+		return
+	}
+
+	entries := global.SearchDirectory(criteria, msnp_search_max_results)
+	if len(entries) == 0 {
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "FND", "0 0"))
+		return
+	}
+
+	for i := 0; i < len(entries); i++ {
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "FND", fmt.Sprintf("%d %d fname=%s lname=%s city=%s state=%s country=%s", i+1, len(entries),
+			encodeDirectoryValue(entries[i].FirstName), encodeDirectoryValue(entries[i].LastName), encodeDirectoryValue(entries[i].City),
+			encodeDirectoryValue(entries[i].State), encodeDirectoryValue(entries[i].Country))))
+	}
+}
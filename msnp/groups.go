@@ -0,0 +1,127 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+)
+
+const (
+	msnp_max_groups            = 30
+	msnp_max_group_name_length = 61
+)
+
+func parseGroupName(client *global.Client, data string, name string) (string, bool) {
+	unescaped, err := url.PathUnescape(name)
+	if err != nil || unescaped == "" || len(unescaped) > msnp_max_group_name_length {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "229"))
+		return "", false
+	}
+	return unescaped, true
+}
+
+func groupNameTaken(owner int, name string, except int) bool {
+	groups := getGroups(owner)
+	for i := 0; i < len(groups); i++ {
+		if groups[i].groupid != except && groups[i].name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ADG <trid> <name> 0
+func handleClientPacketAddGroup(client *global.Client, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_groups) {
+		return
+	}
+
+	name, ok := parseGroupName(client, data, findValueFromData("ADG", data, 1))
+	if !ok {
+		return
+	}
+
+	uid := client.Account.UserId
+	groups := getGroups(uid)
+	if len(groups) >= msnp_max_groups {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "223"))
+		return
+	}
+	if groupNameTaken(uid, name, -1) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "228"))
+		return
+	}
+
+	groupid := 1
+	for i := 0; i < len(groups); i++ {
+		if groups[i].groupid >= groupid {
+			groupid = groups[i].groupid + 1
+		}
+	}
+
+	if !queryList("INSERT INTO msngroups (`owner_id`, `groupid`, `name`) VALUES (?, ?, ?)", uid, groupid, name) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+		return
+	}
+
+	version := global.IncrementListVersion(uid)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "ADG", fmt.Sprintf("%d %s %d 0", version, url.PathEscape(name), groupid)))
+}
+
+// RMG <trid> <groupid>, contacts left without a group fall back to group 0
+func handleClientPacketRemoveGroup(client *global.Client, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_groups) {
+		return
+	}
+
+	uid := client.Account.UserId
+	groupid, err := strconv.Atoi(findValueFromData("RMG", data, 1))
+	if err != nil || !groupExists(uid, groupid) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "224"))
+		return
+	}
+	if groupid == 0 {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "230"))
+		return
+	}
+
+	queryList("DELETE from msngroupmembers WHERE owner_id= ? AND groupid= ?", uid, groupid)
+	queryList("DELETE from msngroups WHERE owner_id= ? AND groupid= ?", uid, groupid)
+
+	version := global.IncrementListVersion(uid)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "RMG", fmt.Sprintf("%d %d", version, groupid)))
+}
+
+// REG <trid> <groupid> <name> 0
+func handleClientPacketRenameGroup(client *global.Client, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_groups) {
+		return
+	}
+
+	uid := client.Account.UserId
+	groupid, err := strconv.Atoi(findValueFromData("REG", data, 1))
+	if err != nil || !groupExists(uid, groupid) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "224"))
+		return
+	}
+
+	name, ok := parseGroupName(client, data, findValueFromData("REG", data, 2))
+	if !ok {
+		return
+	}
+	if groupNameTaken(uid, name, groupid) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "228"))
+		return
+	}
+
+	// the default group only gets a row once it is renamed
+	if !queryList("INSERT INTO msngroups (`owner_id`, `groupid`, `name`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE name= VALUES(name)", uid, groupid, name) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+		return
+	}
+
+	version := global.IncrementListVersion(uid)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "REG", fmt.Sprintf("%d %d %s 0", version, groupid, url.PathEscape(name))))
+}
@@ -2,8 +2,11 @@ package msnp
 
 import (
 	"bytes"
+	crand "crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"strconv"
 	"strings"
 )
 
@@ -29,7 +32,7 @@ func findValueFromData(data_search string, packet string, offset int) string {
 	splits := strings.Split(decode, " ")
 
 	for ix := 0; ix < len(splits); ix++ {
-		if splits[ix] == data_search {
+		if splits[ix] == data_search && ix+1+offset < len(splits) {
 			//return splits[ix+1+len(offset)]
 			//string(bytes.Trim([]byte(splits[1]), "\x00"))
 			return string(bytes.Trim([]byte(splits[ix+1+offset]), "\x00"))
@@ -39,17 +42,92 @@ func findValueFromData(data_search string, packet string, offset int) string {
 	return ""
 }
 
+// lines without one (PNG, or garbage from a client) are answered with trid 0
 func getTrId(data string, cmd string) string {
 	decode := strings.Replace(data, "\r\n", "", -1)
 	splits := strings.Split(decode, " ")
 
-	return string(bytes.Trim([]byte(splits[1]), "\x00"))
+	if len(splits) < 2 {
+		return "0"
+	}
+
+	trid := string(bytes.Trim([]byte(splits[1]), "\x00"))
+	if trid == "" {
+		return "0"
+	}
+	return trid
 }
 
 func generateContextKey() int {
 	return rand.Intn(100000)
 }
 
+// switchboard cookies are handed to the client in XFR and RNG and have to be presented again in USR and ANS
+func generateCookie() string {
+	raw := make([]byte, 12)
+	crand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+// commands that carry a payload announce its length as their last parameter
+var msnp_payload_commands = map[string]bool{
+	"MSG": true,
+	"QRY": true,
+}
+
+// largest payload a MSG or QRY may announce, messages from real clients stay well below
+const msnp_max_payload = 16 * 1024
+
+/*
+splits a read buffer into complete commands, payload commands are returned
+together with their payload, whatever is incomplete is handed back to be
+completed by the next read. ok is false once a command announces a payload
+larger than msnp_max_payload, the connection should be dropped then
+*/
+func splitCommandFrames(pending []byte) ([]string, []byte, bool) {
+	var frames []string
+
+	for {
+		end := bytes.Index(pending, []byte("\r\n"))
+		if end < 0 {
+			return frames, pending, true
+		}
+
+		line := string(pending[:end])
+		splits := strings.Split(line, " ")
+
+		if msnp_payload_commands[splits[0]] && len(splits) > 1 {
+			length, err := strconv.Atoi(splits[len(splits)-1])
+			if err == nil && length > msnp_max_payload {
+				return frames, pending, false
+			}
+			if err == nil && length >= 0 {
+				if len(pending) < end+2+length {
+					return frames, pending, true
+				}
+
+				frames = append(frames, string(pending[:end+2+length]))
+				pending = pending[end+2+length:]
+				continue
+			}
+		}
+
+		if line != "" {
+			frames = append(frames, line)
+		}
+		pending = pending[end+2:]
+	}
+}
+
+// splits a payload command into its command line and payload
+func splitPayload(frame string) (string, string) {
+	end := strings.Index(frame, "\r\n")
+	if end < 0 {
+		return frame, ""
+	}
+	return frame[:end], frame[end+2:]
+}
+
 func addUserContext(ctx *msnp_context) {
 	msn_context_list = append(msn_context_list, ctx)
 }
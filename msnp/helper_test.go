@@ -0,0 +1,54 @@
+package msnp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommandFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending string
+		frames  []string
+		rest    string
+		ok      bool
+	}{
+		{"single command", "PNG\r\n", []string{"PNG"}, "", true},
+		{"several commands in one read", "CHG 5 NLN 0\r\nSYN 6 0\r\n", []string{"CHG 5 NLN 0", "SYN 6 0"}, "", true},
+		{"partial command", "CHG 5 NL", nil, "CHG 5 NL", true},
+		{"payload split on line breaks", "MSG 3 N 6\r\na\r\nb\r\nPNG\r\n", []string{"MSG 3 N 6\r\na\r\nb\r\n", "PNG"}, "", true},
+		{"partial payload", "QRY 9 msmsgs@msnmsgr.com 32\r\n0123", nil, "QRY 9 msmsgs@msnmsgr.com 32\r\n0123", true},
+		{"empty lines are skipped", "\r\nPNG\r\n", []string{"PNG"}, "", true},
+		{"oversized length", "MSG 3 N 999999999\r\n", nil, "MSG 3 N 999999999\r\n", false},
+		{"oversized length after a frame", "PNG\r\nMSG 3 N " + strings.Repeat("9", 7) + "\r\n", []string{"PNG"}, "MSG 3 N 9999999\r\n", false},
+	}
+
+	for _, test := range tests {
+		frames, rest, ok := splitCommandFrames([]byte(test.pending))
+		if !reflect.DeepEqual(frames, test.frames) || string(rest) != test.rest || ok != test.ok {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q, %v", test.name, frames, rest, ok, test.frames, test.rest, test.ok)
+		}
+	}
+}
+
+func TestGetTrId(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"CHG 5 NLN 0\r\n", "5"},
+		{"USR 12 TWN I a@b.c", "12"},
+		{"FOO\r\n", "0"},
+		{"FOO", "0"},
+		{"", "0"},
+		{"FOO \r\n", "0"},
+		{"SYN 7\x00\x00", "7"},
+	}
+
+	for _, test := range tests {
+		if got := getTrId(test.data, ""); got != test.want {
+			t.Errorf("getTrId(%q) = %q, want %q", test.data, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,564 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+)
+
+/*
+	FL -> forward list, the contacts table
+	AL -> allow list, msnlists
+	BL -> block list, msnlists
+	RL -> reverse list, everyone who has the user on their forward list
+
+	GTC A|N   -> prompt (A) or not (N) when someone adds the user
+	BLP AL|BL -> whether users on neither AL nor BL are allowed (AL) or blocked (BL)
+*/
+
+const (
+	msnp_list_forward = "FL"
+	msnp_list_allow   = "AL"
+	msnp_list_block   = "BL"
+	msnp_list_reverse = "RL"
+)
+
+const msnp_default_group_name = "Other Contacts"
+
+type msnp_list_entry struct {
+	userid int
+	email  string
+	nick   string
+	groups []int
+}
+
+type msnp_group struct {
+	groupid int
+	name    string
+}
+
+func getProtocolVersion(client *global.Client) int {
+	version, _ := strconv.Atoi(strings.Replace(client.Protocol, "MSNP", "", -1))
+	return version
+}
+
+func queryListEntries(query string, args ...interface{}) []msnp_list_entry {
+	var entries []msnp_list_entry
+
+	res, err := util.GetDatabaseHandle().Query(query, args...)
+	if err != nil {
+		util.Error("MSNP -> queryListEntries", err.Error())
+		return entries
+	}
+
+	for res.Next() {
+		var entry msnp_list_entry
+		res.Scan(&entry.userid, &entry.email, &entry.nick)
+		entries = append(entries, entry)
+	}
+	res.Close()
+
+	return entries
+}
+
+func getListEntries(uid int, list string) []msnp_list_entry {
+	switch list {
+	case msnp_list_forward:
+		entries := queryListEntries(msnp_forward_list_query, uid)
+		for i := 0; i < len(entries); i++ {
+			entries[i].groups = getContactGroups(uid, entries[i].userid)
+		}
+		return entries
+	case msnp_list_reverse:
+		return queryListEntries("SELECT a.id, a.email, a.screenname from contacts c INNER JOIN accounts a ON a.id = c.from_id WHERE c.to_id= ?", uid)
+	case msnp_list_allow, msnp_list_block:
+		return queryListEntries("SELECT a.id, a.email, a.screenname from msnlists l INNER JOIN accounts a ON a.id = l.contact_id WHERE l.owner_id= ? AND l.list= ?", uid, list)
+	}
+
+	return nil
+}
+
+func getListSettings(uid int) (string, string) {
+	gtc := "A"
+	blp := "AL"
+
+	res, err := util.GetDatabaseHandle().Query("SELECT gtc, blp from msn WHERE id= ?", uid)
+	if err != nil {
+		util.Error("MSNP -> getListSettings", err.Error())
+		return gtc, blp
+	}
+
+	if res.Next() {
+		res.Scan(&gtc, &blp)
+	}
+	res.Close()
+
+	return gtc, blp
+}
+
+// users without groups of their own still need the default group 0
+func getGroups(uid int) []msnp_group {
+	groups := []msnp_group{}
+
+	res, err := util.GetDatabaseHandle().Query("SELECT groupid, name from msngroups WHERE owner_id= ? ORDER BY groupid", uid)
+	if err != nil {
+		util.Error("MSNP -> getGroups", err.Error())
+	} else {
+		for res.Next() {
+			var group msnp_group
+			res.Scan(&group.groupid, &group.name)
+			groups = append(groups, group)
+		}
+		res.Close()
+	}
+
+	if len(groups) == 0 || groups[0].groupid != 0 {
+		groups = append([]msnp_group{{groupid: 0, name: msnp_default_group_name}}, groups...)
+	}
+
+	return groups
+}
+
+// contacts that were never put into a group live in group 0
+func getContactGroups(owner int, contact int) []int {
+	var groups []int
+
+	res, err := util.GetDatabaseHandle().Query("SELECT groupid from msngroupmembers WHERE owner_id= ? AND contact_id= ? ORDER BY groupid", owner, contact)
+	if err != nil {
+		util.Error("MSNP -> getContactGroups", err.Error())
+		return []int{0}
+	}
+
+	for res.Next() {
+		var group int
+		res.Scan(&group)
+		groups = append(groups, group)
+	}
+	res.Close()
+
+	if len(groups) == 0 {
+		return []int{0}
+	}
+
+	return groups
+}
+
+func buildGroupList(groups []int) string {
+	ids := make([]string, len(groups))
+	for i := 0; i < len(groups); i++ {
+		ids[i] = strconv.Itoa(groups[i])
+	}
+	return strings.Join(ids, ",")
+}
+
+func sendList(client *global.Client, data string, list string, version int) {
+	entries := getListEntries(client.Account.UserId, list)
+
+	if len(entries) == 0 {
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "LST", fmt.Sprintf("%s %d 0 0", list, version)))
+		return
+	}
+
+	for i := 0; i < len(entries); i++ {
+		args := fmt.Sprintf("%s %d %d %d %s %s", list, version, i+1, len(entries), entries[i].email, url.PathEscape(entries[i].nick))
+		if list == msnp_list_forward && getProtocolVersion(client) >= msnp_version_groups {
+			args += " " + buildGroupList(entries[i].groups)
+		}
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "LST", args))
+
+		if list == msnp_list_forward && getProtocolVersion(client) >= msnp_version_phones && isAllowedToSee(entries[i].userid, client.Account.UserId) {
+			phones := getPhoneNumbers(entries[i].userid)
+			for ix := 0; ix < len(phones); ix++ {
+				util.WriteTraffic(client.Connection, fmt.Sprintf("BPR %d %s %s %s\r\n", version, entries[i].email, phones[ix].kind, phones[ix].number))
+			}
+		}
+	}
+}
+
+// MSNP8 sends every user once with the lists they are on as bits
+var msnp_list_bits = map[string]int{
+	msnp_list_forward: 1,
+	msnp_list_allow:   2,
+	msnp_list_block:   4,
+	msnp_list_reverse: 8,
+}
+
+func sendListsMSNP8(client *global.Client, data string, version int) {
+	var order []int
+	entries := map[int]*msnp_list_entry{}
+	bits := map[int]int{}
+
+	lists := []string{msnp_list_forward, msnp_list_allow, msnp_list_block, msnp_list_reverse}
+	for i := 0; i < len(lists); i++ {
+		list := getListEntries(client.Account.UserId, lists[i])
+		for ix := 0; ix < len(list); ix++ {
+			if _, ok := entries[list[ix].userid]; !ok {
+				entry := list[ix]
+				entries[entry.userid] = &entry
+				order = append(order, entry.userid)
+			}
+			bits[list[ix].userid] |= msnp_list_bits[lists[i]]
+		}
+	}
+
+	groups := getGroups(client.Account.UserId)
+	gtc, blp := getListSettings(client.Account.UserId)
+
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "SYN", fmt.Sprintf("%d %d %d", version, len(order), len(groups))))
+	util.WriteTraffic(client.Connection, fmt.Sprintf("GTC %s\r\n", gtc))
+	util.WriteTraffic(client.Connection, fmt.Sprintf("BLP %s\r\n", blp))
+
+	phones := getPhoneNumbers(client.Account.UserId)
+	for i := 0; i < len(phones); i++ {
+		util.WriteTraffic(client.Connection, fmt.Sprintf("PRP %s %s\r\n", phones[i].kind, phones[i].number))
+	}
+
+	for i := 0; i < len(groups); i++ {
+		util.WriteTraffic(client.Connection, fmt.Sprintf("LSG %d %s 0\r\n", groups[i].groupid, url.PathEscape(groups[i].name)))
+	}
+
+	for i := 0; i < len(order); i++ {
+		entry := entries[order[i]]
+
+		line := fmt.Sprintf("LST %s %s %d", entry.email, url.PathEscape(entry.nick), bits[entry.userid])
+		if bits[entry.userid]&msnp_list_bits[msnp_list_forward] != 0 {
+			line += " " + buildGroupList(entry.groups)
+		}
+		util.WriteTraffic(client.Connection, line+"\r\n")
+
+		if bits[entry.userid]&msnp_list_bits[msnp_list_forward] != 0 && isAllowedToSee(entry.userid, client.Account.UserId) {
+			phones := getPhoneNumbers(entry.userid)
+			for ix := 0; ix < len(phones); ix++ {
+				util.WriteTraffic(client.Connection, fmt.Sprintf("BPR %s %s\r\n", phones[ix].kind, phones[ix].number))
+			}
+		}
+	}
+}
+
+// SYN <trid> <version>, the lists are only sent when the client's copy is outdated
+func handleClientPacketContactListSynchronization(client *global.Client, data string) {
+	version := global.GetListVersion(client.Account.UserId)
+
+	if getProtocolVersion(client) >= msnp_version_twn {
+		if findValueFromData("SYN", data, 1) == strconv.Itoa(version) {
+			util.WriteTraffic(client.Connection, msnp_new_command(data, "SYN", strconv.Itoa(version)))
+			return
+		}
+		sendListsMSNP8(client, data, version)
+		return
+	}
+
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "SYN", strconv.Itoa(version)))
+
+	if findValueFromData("SYN", data, 1) == strconv.Itoa(version) {
+		util.Debug("MSNP -> handleClientPacketContactListSynchronization", "Contact list of %s is up to date (%d)", client.Account.Email, version)
+		return
+	}
+
+	gtc, blp := getListSettings(client.Account.UserId)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "GTC", fmt.Sprintf("%d %s", version, gtc)))
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "BLP", fmt.Sprintf("%d %s", version, blp)))
+
+	if getProtocolVersion(client) >= msnp_version_phones {
+		phones := getPhoneNumbers(client.Account.UserId)
+		for i := 0; i < len(phones); i++ {
+			util.WriteTraffic(client.Connection, msnp_new_command(data, "PRP", fmt.Sprintf("%d %s %s", version, phones[i].kind, phones[i].number)))
+		}
+	}
+
+	if getProtocolVersion(client) >= msnp_version_groups {
+		groups := getGroups(client.Account.UserId)
+		for i := 0; i < len(groups); i++ {
+			util.WriteTraffic(client.Connection, msnp_new_command(data, "LSG", fmt.Sprintf("%d %d %d %d %s 0", version, i+1, len(groups), groups[i].groupid, url.PathEscape(groups[i].name))))
+		}
+	}
+
+	sendList(client, data, msnp_list_forward, version)
+	sendList(client, data, msnp_list_allow, version)
+	sendList(client, data, msnp_list_block, version)
+	sendList(client, data, msnp_list_reverse, version)
+}
+
+func storeListSetting(uid int, column string, value string) int {
+	res, err := util.GetDatabaseHandle().Query("INSERT INTO msn (`id`, `clversion`, `"+column+"`) VALUES (?, 0, ?) ON DUPLICATE KEY UPDATE "+column+"= VALUES("+column+")", uid, value)
+	if err != nil {
+		util.Error("MSNP -> storeListSetting", err.Error())
+		return global.GetListVersion(uid)
+	}
+	res.Close()
+
+	return global.IncrementListVersion(uid)
+}
+
+// GTC <trid> A|N
+func handleClientPacketSetReverseListPrompting(client *global.Client, data string) {
+	setting := findValueFromData("GTC", data, 1)
+	if setting != "A" && setting != "N" {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	version := storeListSetting(client.Account.UserId, "gtc", setting)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "GTC", fmt.Sprintf("%d %s", version, setting)))
+}
+
+// BLP <trid> AL|BL
+func handleClientPacketSetPrivacyMode(client *global.Client, data string) {
+	setting := findValueFromData("BLP", data, 1)
+	if setting != msnp_list_allow && setting != msnp_list_block {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	version := storeListSetting(client.Account.UserId, "blp", setting)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "BLP", fmt.Sprintf("%d %s", version, setting)))
+
+	if ctx := getNotificationContext(client.Account.Email); ctx != nil && ctx.status != "" {
+		watchers := getListEntries(client.Account.UserId, msnp_list_reverse)
+		for i := 0; i < len(watchers); i++ {
+			handleClientRefreshPresence(client, watchers[i].userid)
+		}
+	}
+}
+
+// clients log in with @hotmail.com addresses, accounts are stored with the configured mail domain
+func normalizeEmail(mail string) string {
+	return strings.Replace(mail, "@hotmail.com", util.GetMailDomain(), -1)
+}
+
+func isOnList(owner int, contact int, list string) bool {
+	var count int
+
+	query := "SELECT COUNT(*) from msnlists WHERE owner_id= ? AND contact_id= ? AND list= ?"
+	args := []interface{}{owner, contact, list}
+	if list == msnp_list_forward {
+		query = "SELECT COUNT(*) from contacts WHERE from_id= ? AND to_id= ?"
+		args = args[:2]
+	}
+
+	res, err := util.GetDatabaseHandle().Query(query, args...)
+	if err != nil {
+		util.Error("MSNP -> isOnList", err.Error())
+		return false
+	}
+
+	res.Next()
+	res.Scan(&count)
+	res.Close()
+
+	return count > 0
+}
+
+func isInGroup(owner int, contact int, groupid int) bool {
+	groups := getContactGroups(owner, contact)
+	for i := 0; i < len(groups); i++ {
+		if groups[i] == groupid {
+			return true
+		}
+	}
+	return false
+}
+
+func groupExists(owner int, groupid int) bool {
+	groups := getGroups(owner)
+	for i := 0; i < len(groups); i++ {
+		if groups[i].groupid == groupid {
+			return true
+		}
+	}
+	return false
+}
+
+func queryList(query string, args ...interface{}) bool {
+	res, err := util.GetDatabaseHandle().Query(query, args...)
+	if err != nil {
+		util.Error("MSNP -> queryList", err.Error())
+		return false
+	}
+	res.Close()
+	return true
+}
+
+// the notification server connection of an online msnp user
+func getNotificationClient(uid int) *global.Client {
+	clients := global.SnapshotClients()
+	for i := 0; i < len(clients); i++ {
+		if clients[i].Client == "MSN Messenger" && clients[i].Account.UserId == uid {
+			return clients[i]
+		}
+	}
+	return nil
+}
+
+// ADD 0 RL / REM 0 RL tell the other side that their reverse list changed
+func sendReverseListChange(cmd string, target int, from global.Account) {
+	client := getNotificationClient(target)
+	if client == nil {
+		return
+	}
+
+	version := global.GetListVersion(target)
+	if cmd == "ADD" {
+		util.WriteTraffic(client.Connection, fmt.Sprintf("ADD 0 RL %d %s %s\r\n", version, from.Email, url.PathEscape(from.Screenname)))
+	} else {
+		util.WriteTraffic(client.Connection, fmt.Sprintf("REM 0 RL %d %s\r\n", version, from.Email))
+	}
+}
+
+// ADD <trid> <list> <email> <nick> [groupid]
+func handleClientPacketAddContactRequest(client *global.Client, data string) {
+	list := findValueFromData("ADD", data, 1)
+	mail := normalizeEmail(findValueFromData("ADD", data, 2))
+	nick := findValueFromData("ADD", data, 3)
+	group := findValueFromData("ADD", data, 4)
+
+	if list != msnp_list_forward && list != msnp_list_allow && list != msnp_list_block {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	if !strings.HasSuffix(mail, util.GetMailDomain()) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	toAcc, _ := global.GetUserDataFromEmail(mail)
+	if toAcc.UserId == 0 {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "205"))
+		return
+	}
+
+	groupid := 0
+	if group != "" {
+		id, err := strconv.Atoi(group)
+		if err != nil || list != msnp_list_forward || !groupExists(client.Account.UserId, id) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "224"))
+			return
+		}
+		groupid = id
+	}
+
+	if nick == "" {
+		nick = url.PathEscape(toAcc.Screenname)
+	}
+
+	uid := client.Account.UserId
+	onlist := isOnList(uid, toAcc.UserId, list)
+
+	switch list {
+	case msnp_list_forward:
+		// an existing forward list entry can still be put into another group
+		if onlist && (group == "" || isInGroup(uid, toAcc.UserId, groupid)) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "215"))
+			return
+		}
+
+		if !onlist && !queryList("INSERT into contacts (`from_id`, `to_id`) VALUES (?, ?)", uid, toAcc.UserId) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+			return
+		}
+		if groupid != 0 {
+			queryList("INSERT IGNORE into msngroupmembers (`owner_id`, `contact_id`, `groupid`) VALUES (?, ?, ?)", uid, toAcc.UserId, groupid)
+		}
+	case msnp_list_allow, msnp_list_block:
+		if onlist {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "215"))
+			return
+		}
+
+		opposite := msnp_list_block
+		if list == msnp_list_block {
+			opposite = msnp_list_allow
+		}
+		if isOnList(uid, toAcc.UserId, opposite) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "219"))
+			return
+		}
+
+		if !queryList("INSERT into msnlists (`owner_id`, `contact_id`, `list`) VALUES (?, ?, ?)", uid, toAcc.UserId, list) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+			return
+		}
+	}
+
+	version := global.IncrementListVersion(uid)
+
+	args := fmt.Sprintf("%s %d %s %s", list, version, toAcc.Email, nick)
+	if list == msnp_list_forward && getProtocolVersion(client) >= msnp_version_groups {
+		args += " " + strconv.Itoa(groupid)
+	}
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "ADD", args))
+
+	if list == msnp_list_forward && !onlist {
+		global.IncrementListVersion(toAcc.UserId)
+		sendReverseListChange("ADD", toAcc.UserId, client.Account)
+	} else if list != msnp_list_forward {
+		handleClientRefreshPresence(client, toAcc.UserId)
+	}
+}
+
+// REM <trid> <list> <email> [groupid]
+func handleClientPacketRemoveContactRequest(client *global.Client, data string) {
+	list := findValueFromData("REM", data, 1)
+	mail := normalizeEmail(findValueFromData("REM", data, 2))
+	group := findValueFromData("REM", data, 3)
+
+	if list != msnp_list_forward && list != msnp_list_allow && list != msnp_list_block {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	toAcc, _ := global.GetUserDataFromEmail(mail)
+	if toAcc.UserId == 0 {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "205"))
+		return
+	}
+
+	uid := client.Account.UserId
+	if !isOnList(uid, toAcc.UserId, list) {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "216"))
+		return
+	}
+
+	removed := true
+	switch list {
+	case msnp_list_forward:
+		if group != "" {
+			groupid, err := strconv.Atoi(group)
+			if err != nil || !isInGroup(uid, toAcc.UserId, groupid) {
+				util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "224"))
+				return
+			}
+
+			// leaving one group keeps the contact unless it was the last one
+			removed = len(getContactGroups(uid, toAcc.UserId)) <= 1
+			queryList("DELETE from msngroupmembers WHERE owner_id= ? AND contact_id= ? AND groupid= ?", uid, toAcc.UserId, groupid)
+		}
+
+		if removed {
+			queryList("DELETE from contacts WHERE from_id= ? AND to_id= ?", uid, toAcc.UserId)
+			queryList("DELETE from msngroupmembers WHERE owner_id= ? AND contact_id= ?", uid, toAcc.UserId)
+			global.DeleteContactInfo(uid, toAcc.UserId)
+		}
+	case msnp_list_allow, msnp_list_block:
+		queryList("DELETE from msnlists WHERE owner_id= ? AND contact_id= ? AND list= ?", uid, toAcc.UserId, list)
+	}
+
+	version := global.IncrementListVersion(uid)
+
+	args := fmt.Sprintf("%s %d %s", list, version, toAcc.Email)
+	if group != "" {
+		args += " " + group
+	}
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "REM", args))
+
+	if list == msnp_list_forward && removed {
+		global.IncrementListVersion(toAcc.UserId)
+		sendReverseListChange("REM", toAcc.UserId, client.Account)
+	} else if list != msnp_list_forward {
+		handleClientRefreshPresence(client, toAcc.UserId)
+	}
+}
@@ -0,0 +1,86 @@
+package msnp
+
+import (
+	"fmt"
+	"net"
+	"phantom/global"
+	"phantom/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	hotmail notifications are MSG payloads from the pseudo user Hotmail on the NS
+
+	text/x-msmsgsprofile                  -> right after USR OK, EmailEnabled turns the inbox line on
+	text/x-msmsgsinitialemailnotification -> unread count at login
+	text/x-msmsgsemailnotification        -> a new mail arrived
+
+	the mailbox itself lives in global/mailbox.go, its web inbox at /mail
+*/
+
+const msnp_mail_path = "/mail"
+
+// line breaks in sender or subject would end the notification early
+var msnp_mail_header = strings.NewReplacer("\r", " ", "\n", " ")
+
+func init() {
+	global.RegisterMailNotifier("MSN Messenger", notifyMail)
+}
+
+// clients open the inbox by posting to this url, so it carries its own web token
+func getMailPostUrl(client *global.Client) string {
+	token := global.IssueToken("web", client.Account.UserId, msnp_url_token_ttl)
+	return fmt.Sprintf("http://%s%s?token=%s", util.GetRootUrl(), msnp_mail_path, token)
+}
+
+func sendHotmailMessage(client *global.Client, msg *msnp_mime_message) error {
+	payload := msg.build()
+	return util.WriteTraffic(client.Connection, fmt.Sprintf("MSG Hotmail Hotmail %d\r\n%s", len(payload), payload))
+}
+
+// called after USR OK, sends the profile and the current unread count
+func sendMailboxStatus(client *global.Client) {
+	host, port, _ := net.SplitHostPort(client.Connection.RemoteAddr().String())
+
+	profile := newMimeMessage("text/x-msmsgsprofile; charset=UTF-8")
+	profile.set("LoginTime", strconv.FormatInt(time.Now().Unix(), 10))
+	profile.set("EmailEnabled", "1")
+	profile.set("MemberIdHigh", "0")
+	profile.set("MemberIdLow", strconv.Itoa(client.Account.UserId))
+	profile.set("lang_preference", "1033")
+	profile.set("preferredEmail", client.Account.Email)
+	profile.set("country", "")
+	profile.set("PostalCode", "")
+	profile.set("Gender", "")
+	profile.set("Kid", "0")
+	profile.set("Age", "")
+	profile.set("sid", "507")
+	profile.set("kv", "2")
+	profile.set("ClientIP", host)
+	profile.set("ClientPort", port)
+	sendHotmailMessage(client, profile)
+
+	// all three open the same inbox, so they share one tokenized url
+	inbox := getMailPostUrl(client)
+	initial := newMimeMessage("text/x-msmsgsinitialemailnotification; charset=UTF-8")
+	initial.body = fmt.Sprintf("Inbox-Unread: %d\r\nFolders-Unread: 0\r\nInbox-URL: %s\r\nFolders-URL: %s\r\nPost-URL: %s\r\n\r\n",
+		global.CountUnreadMail(client.Account.UserId), inbox, inbox, inbox)
+	sendHotmailMessage(client, initial)
+}
+
+// only signed in clients are told, the notification carries sender and subject
+func notifyMail(target *global.Client, mail global.Mail) bool {
+	ctx := getNotificationContext(target.Account.Email)
+	if ctx == nil || ctx.status == "" {
+		return false
+	}
+
+	inbox := getMailPostUrl(target)
+	msg := newMimeMessage("text/x-msmsgsemailnotification; charset=UTF-8")
+	msg.body = fmt.Sprintf("From: %s\r\nMessage-URL: %s&id=%d\r\nPost-URL: %s\r\nSubject: %s\r\nDest-Folder: ACTIVE\r\nFrom-Addr: %s\r\nid: 2\r\n\r\n",
+		msnp_mail_header.Replace(mail.FromName), inbox, mail.Id, inbox, msnp_mail_header.Replace(mail.Subject), msnp_mail_header.Replace(mail.FromAddr))
+
+	return sendHotmailMessage(target, msg) == nil
+}
@@ -0,0 +1,138 @@
+package msnp
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+	switchboard payloads are mime-like
+
+	MIME-Version: 1.0
+	Content-Type: text/plain; charset=UTF-8
+	X-MMS-IM-Format: FN=MS%20Sans%20Serif; EF=; CO=0; CS=0; PF=0
+
+	<body>
+
+	text/plain             -> text message
+	text/x-msmsgscontrol   -> typing notification, TypingUser holds the typing user
+	text/x-msmsgsinvite    -> file transfer and application invitations
+*/
+
+const (
+	msnp_message_text = iota
+	msnp_message_typing
+	msnp_message_invite
+	msnp_message_unknown
+)
+
+const msnp_default_im_format = "FN=MS%20Sans%20Serif; EF=; CO=0; CS=0; PF=0"
+
+type msnp_mime_header struct {
+	key   string
+	value string
+}
+
+type msnp_mime_message struct {
+	headers []msnp_mime_header
+	body    string
+}
+
+// payloads without a header block are not messages, ok is false for those
+func parseMimeMessage(payload string) (*msnp_mime_message, bool) {
+	msg := &msnp_mime_message{}
+
+	end := strings.Index(payload, "\r\n\r\n")
+	if end < 0 {
+		return msg, false
+	}
+
+	lines := strings.Split(payload[:end], "\r\n")
+	for i := 0; i < len(lines); i++ {
+		sep := strings.Index(lines[i], ":")
+		if sep < 0 {
+			return msg, false
+		}
+		msg.headers = append(msg.headers, msnp_mime_header{
+			key:   strings.TrimSpace(lines[i][:sep]),
+			value: strings.TrimSpace(lines[i][sep+1:]),
+		})
+	}
+	msg.body = payload[end+4:]
+
+	return msg, msg.get("Content-Type") != ""
+}
+
+func newMimeMessage(contenttype string) *msnp_mime_message {
+	msg := &msnp_mime_message{}
+	msg.set("MIME-Version", "1.0")
+	msg.set("Content-Type", contenttype)
+	return msg
+}
+
+func newTextMessage(text string) *msnp_mime_message {
+	msg := newMimeMessage("text/plain; charset=UTF-8")
+	msg.set("X-MMS-IM-Format", msnp_default_im_format)
+	msg.body = text
+	return msg
+}
+
+func newTypingMessage(email string) *msnp_mime_message {
+	msg := newMimeMessage("text/x-msmsgscontrol")
+	msg.set("TypingUser", email)
+	msg.body = "\r\n"
+	return msg
+}
+
+func (msg *msnp_mime_message) get(key string) string {
+	for i := 0; i < len(msg.headers); i++ {
+		if strings.EqualFold(msg.headers[i].key, key) {
+			return msg.headers[i].value
+		}
+	}
+	return ""
+}
+
+// replaces the header in place or appends it, header order is kept
+func (msg *msnp_mime_message) set(key string, value string) {
+	for i := 0; i < len(msg.headers); i++ {
+		if strings.EqualFold(msg.headers[i].key, key) {
+			msg.headers[i].value = value
+			return
+		}
+	}
+	msg.headers = append(msg.headers, msnp_mime_header{key: key, value: value})
+}
+
+// content type without its parameters, lowercased
+func (msg *msnp_mime_message) contentType() string {
+	contenttype := msg.get("Content-Type")
+	if sep := strings.Index(contenttype, ";"); sep >= 0 {
+		contenttype = contenttype[:sep]
+	}
+	return strings.ToLower(strings.TrimSpace(contenttype))
+}
+
+func (msg *msnp_mime_message) kind() int {
+	switch msg.contentType() {
+	case "text/plain":
+		return msnp_message_text
+	case "text/x-msmsgscontrol":
+		if msg.get("TypingUser") != "" {
+			return msnp_message_typing
+		}
+	case "text/x-msmsgsinvite":
+		return msnp_message_invite
+	}
+	return msnp_message_unknown
+}
+
+func (msg *msnp_mime_message) build() string {
+	var payload strings.Builder
+	for i := 0; i < len(msg.headers); i++ {
+		payload.WriteString(fmt.Sprintf("%s: %s\r\n", msg.headers[i].key, msg.headers[i].value))
+	}
+	payload.WriteString("\r\n")
+	payload.WriteString(msg.body)
+	return payload.String()
+}
@@ -0,0 +1,55 @@
+package msnp
+
+import "testing"
+
+func TestParseMimeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		ok      bool
+		kind    int
+		body    string
+	}{
+		{"text", "MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nhello", true, msnp_message_text, "hello"},
+		{"typing", "MIME-Version: 1.0\r\nContent-Type: text/x-msmsgscontrol\r\nTypingUser: a@b.c\r\n\r\n\r\n", true, msnp_message_typing, "\r\n"},
+		{"control without typing user", "Content-Type: text/x-msmsgscontrol\r\n\r\n", true, msnp_message_unknown, ""},
+		{"invite", "Content-Type: text/x-msmsgsinvite; charset=UTF-8\r\n\r\nInvitation-Command: INVITE\r\n", true, msnp_message_invite, "Invitation-Command: INVITE\r\n"},
+		{"header case is ignored", "content-type: TEXT/PLAIN\r\n\r\nhi", true, msnp_message_text, "hi"},
+		{"no header block", "hello", false, msnp_message_unknown, ""},
+		{"header line without a colon", "MIME-Version 1.0\r\nContent-Type: text/plain\r\n\r\nhi", false, msnp_message_unknown, ""},
+		{"missing content type", "MIME-Version: 1.0\r\n\r\nhi", false, msnp_message_unknown, "hi"},
+	}
+
+	for _, test := range tests {
+		msg, ok := parseMimeMessage(test.payload)
+		if ok != test.ok {
+			t.Errorf("%s: ok = %v, want %v", test.name, ok, test.ok)
+			continue
+		}
+		if msg.kind() != test.kind {
+			t.Errorf("%s: kind = %d, want %d", test.name, msg.kind(), test.kind)
+		}
+		if msg.body != test.body {
+			t.Errorf("%s: body = %q, want %q", test.name, msg.body, test.body)
+		}
+	}
+}
+
+func TestMimeMessageRoundTrip(t *testing.T) {
+	msg, ok := parseMimeMessage(newTextMessage("hi\r\nthere").build())
+	if !ok {
+		t.Fatalf("built message did not parse")
+	}
+	if msg.kind() != msnp_message_text || msg.body != "hi\r\nthere" {
+		t.Errorf("got kind %d body %q", msg.kind(), msg.body)
+	}
+	if msg.get("X-MMS-IM-Format") != msnp_default_im_format {
+		t.Errorf("format = %q", msg.get("X-MMS-IM-Format"))
+	}
+
+	msg.set("content-type", "text/x-msmsgscontrol")
+	msg.set("TypingUser", "a@b.c")
+	if msg.kind() != msnp_message_typing || len(msg.headers) != 4 {
+		t.Errorf("set did not replace in place: %q", msg.headers)
+	}
+}
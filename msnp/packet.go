@@ -1,6 +1,7 @@
 package msnp
 
 import (
+	"bytes"
 	"encoding/hex"
 	"fmt"
 	"phantom/global"
@@ -11,8 +12,17 @@ import (
 )
 
 /*all of this is DS and NS only not SS/SB*/
+// commands a client may send before it is logged in
+var msnp_login_commands = []string{"VER", "INF", "CVR", "USR", "PNG"}
+
 func handleClientIncomingPackets(client *global.Client, ctx *msnp_context, data string) {
 
+	if !ctx.authenticated && !isLoginCommand(data) {
+		// https://wiki.nina.chat/wiki/Protocols/MSNP/Reference/Error_List#302
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "302"))
+		return
+	}
+
 	switch {
 	case strings.HasPrefix(data, "VER"):
 		handleClientPacketNegotiateProtocolVersion(client, data)
@@ -22,18 +32,51 @@ func handleClientIncomingPackets(client *global.Client, ctx *msnp_context, data
 		handleClientPacketAuthentication(client, ctx, data)
 	case strings.HasPrefix(data, "SYN"):
 		handleClientPacketContactListSynchronization(client, data)
+	case strings.HasPrefix(data, "GTC"):
+		handleClientPacketSetReverseListPrompting(client, data)
+	case strings.HasPrefix(data, "BLP"):
+		handleClientPacketSetPrivacyMode(client, data)
 	case strings.HasPrefix(data, "CHG"):
 		handleClientPacketChangeStatusRequest(client, ctx, data)
 	case strings.HasPrefix(data, "CVR"):
 		handleClientPacketGetClientServerInformation(client, data)
 	case strings.HasPrefix(data, "ADD"):
-		handleClientPacketUpdateContactRequest(client, data)
+		handleClientPacketAddContactRequest(client, data)
+	case strings.HasPrefix(data, "REM"):
+		handleClientPacketRemoveContactRequest(client, data)
 	case strings.HasPrefix(data, "XFR"):
 		handleClientPacketSwitchboardSessionRequest(client, ctx, data)
+	case strings.HasPrefix(data, "REA"):
+		handleClientPacketRenameRequest(client, ctx, data)
+	case strings.HasPrefix(data, "PRP"):
+		handleClientPacketSetPhoneNumber(client, data)
+	case strings.HasPrefix(data, "ADG"):
+		handleClientPacketAddGroup(client, data)
+	case strings.HasPrefix(data, "RMG"):
+		handleClientPacketRemoveGroup(client, data)
+	case strings.HasPrefix(data, "REG"):
+		handleClientPacketRenameGroup(client, data)
+	case strings.HasPrefix(data, "URL"):
+		handleClientPacketServiceUrl(client, data)
+	case strings.HasPrefix(data, "QRY"):
+		handleClientPacketChallengeResponse(client, ctx, data)
+	case strings.HasPrefix(data, "FND"):
+		handleClientPacketDirectorySearch(client, data)
+	case strings.HasPrefix(data, "PNG"):
+		handleClientPacketPing(client)
 	}
 
 }
 
+func isLoginCommand(data string) bool {
+	for i := 0; i < len(msnp_login_commands); i++ {
+		if strings.HasPrefix(data, msnp_login_commands[i]) {
+			return true
+		}
+	}
+	return false
+}
+
 func handleClientLogoutRequest(data string) bool {
 	if strings.HasPrefix(data, "OUT") {
 		return true
@@ -42,29 +85,41 @@ func handleClientLogoutRequest(data string) bool {
 	}
 }
 
+// picks the newest version both sides speak, MSNP8+ clients expect CVR0 to be echoed back
 func handleClientProtocolVersionRequest(client *global.Client, data string) bool {
 
-	var versions []string
+	protover := 0
+	cvr := false
 	splits := strings.Split(strings.Replace(data, "\r\n", "", -1), " ")
-	for ix := 2; ix < len(splits)-1; ix++ {
-		versions = append(versions, splits[ix])
-	}
+	for ix := 2; ix < len(splits); ix++ {
+		version := string(bytes.Trim([]byte(splits[ix]), "\x00"))
+		if version == "CVR0" {
+			cvr = true
+			continue
+		}
 
-	protoverstr := versions[len(versions)-1]
-	util.Debug("MSNP -> handleProtocolVersionRequest", "ver: %s", protoverstr)
+		parsed, err := strconv.Atoi(strings.TrimPrefix(version, "MSNP"))
+		if err == nil && strings.HasPrefix(version, "MSNP") && parsed <= msnp_max_version && parsed > protover {
+			protover = parsed
+		}
+	}
 
-	protoverstripped := strings.Replace(protoverstr, "MSNP", "", -1)
-	protover, _ := strconv.Atoi(protoverstripped)
+	util.Debug("MSNP -> handleProtocolVersionRequest", "ver: MSNP%d", protover)
 
-	if protover <= 7 {
-		client.Protocol = protoverstr
-		util.Debug("MSNP -> handleProtocolVersionRequest", fmt.Sprintf("TrID Dbg: %v", []byte(getTrId(data, "VER"))))
-		util.WriteTraffic(client.Connection, msnp_new_command(data, "VER", protoverstr))
-		return true
-	} else {
+	if protover < 2 {
 		util.WriteTraffic(client.Connection, msnp_new_command(data, "VER", "CVR0"))
 		return false
 	}
+
+	protoverstr := fmt.Sprintf("MSNP%d", protover)
+	client.Protocol = protoverstr
+	util.Debug("MSNP -> handleProtocolVersionRequest", fmt.Sprintf("TrID Dbg: %v", []byte(getTrId(data, "VER"))))
+
+	if protover >= msnp_version_twn && cvr {
+		protoverstr += " CVR0"
+	}
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "VER", protoverstr))
+	return true
 }
 
 func handleClientPacketNegotiateProtocolVersion(client *global.Client, data string) {
@@ -79,8 +134,10 @@ func handleClientPacketAuthenticationMethod(client *global.Client, ctx *msnp_con
 
 	if protover <= 2 {
 		authmethod = "CTP"
-	} else if protover > 2 && protover <= 7 {
+	} else if protover > 2 && protover < msnp_version_twn {
 		authmethod = "MD5"
+	} else {
+		authmethod = "TWN"
 	}
 
 	ctx.authmethod = authmethod
@@ -93,12 +150,16 @@ func handleClientPacketAuthentication(client *global.Client, ctx *msnp_context,
 	if !ctx.dispatched {
 		util.WriteTraffic(client.Connection, msnp_new_command(data, "XFR", fmt.Sprintf("NS %s:1864", util.GetRootUrl())))
 		util.Log("MSN Messenger", "Redirecting Client to Notification Server...")
+	} else if ctx.authenticated {
+		// https://wiki.nina.chat/wiki/Protocols/MSNP/Reference/Error_List#207
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "207"))
+	} else if findValueFromData("USR", data, 1) == "TWN" {
+		handleClientPacketTweenerAuthentication(client, ctx, data)
 	} else {
 
 		account := strings.Replace(findValueFromData("I", data, 0), "@hotmail.com", util.GetMailDomain(), -1)
-		ctx.email = account
-		client.Account, _ = global.GetUserDataFromEmail(account)
-		password := strings.Replace(util.DecryptAES(util.GetAESKey(), client.Account.Password), "\r\n", "", -1)
+		acc, _ := global.GetUserDataFromEmail(account)
+		password := strings.Replace(util.DecryptAES(util.GetAESKey(), acc.Password), "\r\n", "", -1)
 		var clpw string
 
 		util.Debug("MSNP -> handleClientPacketAuthenticationBegin", "aes test: %s", util.GetAESKey())
@@ -112,22 +173,26 @@ func handleClientPacketAuthentication(client *global.Client, ctx *msnp_context,
 			clpw = strings.Replace(findValueFromData("I", data, 1), "\r\n", "", -1)
 
 		} else if ctx.authmethod == "MD5" {
-			saltpw := fmt.Sprintf("%s%s", hex.EncodeToString([]byte(fmt.Sprintf("%d", client.Account.RegistrationTime))), password)
+			saltpw := fmt.Sprintf("%s%s", hex.EncodeToString([]byte(fmt.Sprintf("%d", acc.RegistrationTime))), password)
 			//unix :=
 
-			util.Debug("MSNP -> handleClientPacketAuthenticationBegin -> MD5", "md5 salt test: %v", []byte(hex.EncodeToString([]byte(fmt.Sprintf("%d", client.Account.RegistrationTime)))))
+			util.Debug("MSNP -> handleClientPacketAuthenticationBegin -> MD5", "md5 salt test: %v", []byte(hex.EncodeToString([]byte(fmt.Sprintf("%d", acc.RegistrationTime)))))
 			util.Debug("MSNP -> handleClientPacketAuthenticationBegin -> MD5", "pw data MD5 test: %v", []byte(password))
 			util.Debug("MSNP -> handleClientPacketAuthenticationBegin -> MD5", "pw data MD5 test2: %v", []byte(util.HashMD5(saltpw)))
 			util.Debug("MSNP -> handleClientPacketAuthenticationBegin -> MD5", "pw data MD5 test2 plain: %v", util.HashMD5(saltpw))
 
-			util.WriteTraffic(client.Connection, msnp_new_command(data, "USR", fmt.Sprintf("MD5 S %s", hex.EncodeToString([]byte(fmt.Sprintf("%d", client.Account.RegistrationTime))))))
+			util.WriteTraffic(client.Connection, msnp_new_command(data, "USR", fmt.Sprintf("MD5 S %s", hex.EncodeToString([]byte(fmt.Sprintf("%d", acc.RegistrationTime))))))
 
 			datanew, _ := util.ReadTraffic(client.Connection)
 			clpw = findValueFromData("MD5", string(datanew), 1)
 			password = util.HashMD5(saltpw)
 		}
 
-		if clpw == password {
+		if acc.UserId != 0 && clpw == password {
+			ctx.email = account
+			ctx.authenticated = true
+			client.Account = acc
+
 			// manually increase trid if not md5
 			trid, _ := strconv.Atoi(getTrId(data, "USR"))
 
@@ -139,6 +204,7 @@ func handleClientPacketAuthentication(client *global.Client, ctx *msnp_context,
 			resp := fmt.Sprintf("USR %d OK %s %s\r\n", trid, client.Account.Email, client.Account.Screenname)
 
 			util.WriteTraffic(client.Connection, resp)
+			sendMailboxStatus(client)
 		} else {
 			//https://wiki.nina.chat/wiki/Protocols/MSNP/Reference/Error_List#911
 			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "911"))
@@ -146,38 +212,6 @@ func handleClientPacketAuthentication(client *global.Client, ctx *msnp_context,
 	}
 }
 
-func handleClientPacketContactListSynchronization(client *global.Client, data string) {
-
-	var clv int
-
-	res, _ := util.GetDatabaseHandle().Query("SELECT clversion from msn WHERE id=?", client.Account.UserId)
-	res.Scan(&clv)
-
-	util.WriteTraffic(client.Connection, msnp_new_command(data, "SYN", strconv.Itoa(clv)))
-
-	//todo
-	if findValueFromData("SYN", data, 1) == strconv.Itoa(clv) {
-
-		res, _ = util.GetDatabaseHandle().Query("SELECT * from contacts WHERE from_id=?", client.Account.UserId)
-
-		for res.Next() {
-			var contact global.Contact
-			res.Scan(&contact.FromId, &contact.ToId)
-
-		}
-
-	}
-
-}
-
-func handleClientPacketChangeStatusRequest(client *global.Client, ctx *msnp_context, data string) {
-
-	//todo
-	util.WriteTraffic(client.Connection, msnp_new_command(data, "CHG", findValueFromData("CHG", data, 1)))
-
-	ctx.status = findValueFromData("CHG", data, 1)
-}
-
 // [MySpaceIM] Client Authenticated! | Username: test@phantom-im.xyz | Screenname: TestUser | Version: 1.0.595.0
 func handleClientPacketGetClientServerInformation(client *global.Client, data string) {
 
@@ -189,62 +223,19 @@ func handleClientPacketGetClientServerInformation(client *global.Client, data st
 	util.Log("MSN Messenger", "Client Authenticated! -> Email: %s, Screenname: %s, Version: %s, Protocol Version: %s", client.Account.Email, client.Account.Screenname, client.BuildNumber, client.Protocol)
 }
 
-/*todo*/
-func handleClientPacketUpdateContactRequest(client *global.Client, data string) {
-	list := findValueFromData("ADD", data, 1)
-	mail := findValueFromData("ADD", data, 3)
-
-	if !strings.Contains(data, "@hotmail.com") || !strings.Contains(data, util.GetMailDomain()) {
-		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
-	}
-
-	var count int
-	check2, _ := util.GetDatabaseHandle().Query("SELECT COUNT(*) from accounts WHERE email=?", mail)
-	check2.Next()
-	check2.Scan(&count)
-	check2.Close()
-
-	if count < 1 {
-		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "205"))
-	}
-
-	var username string
-	if strings.Contains(mail, "@hotmail.com") {
-		username = strings.Replace(mail, "@hotmail.com", "", -1)
-	} else if strings.Contains(mail, util.GetMailDomain()) {
-		username = strings.Replace(mail, util.GetMailDomain(), "", -1)
-	}
-
-	toAcc, _ := global.GetUserDataFromUsername(username)
-
-	check2, _ = util.GetDatabaseHandle().Query("SELECT COUNT(*) from contacts WHERE to_id=? and from_id= ?", toAcc.UserId, client.Account.UserId)
-	check2.Next()
-	check2.Scan(&count)
-	check2.Close()
-
-	if count > 0 {
-		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "215"))
-	}
-
-	if list == "AL" {
-
-	} else if list == "BL" {
-
-	} else if list == "FL" {
-
-		util.WriteTraffic(client.Connection, msnp_new_command(data, "ADD", fmt.Sprintf("%s 1 %s %s", list, mail, mail)))
-	}
-}
-
 func handleClientPacketSwitchboardSessionRequest(client *global.Client, ctx *msnp_context, data string) {
-	date := time.Now().UTC().UnixMilli()
 	sbctx := msnp_switchboard_context{
-		authentication: strconv.FormatInt(date, 16),
+		username:       client.Account.Screenname,
 		email:          client.Account.Email,
+		authentication: generateCookie(),
 		nscontext:      ctx,
 		nsinterface:    client.Connection,
+		created:        time.Now(),
 	}
+
+	switchboardLock.Lock()
 	addSwitchboardContext(&sbctx)
+	switchboardLock.Unlock()
 
-	util.WriteTraffic(client.Connection, msnp_new_command(data, "XFR", fmt.Sprintf("SB %s:1865 CKI %s", util.GetRootUrl(), sbctx.authentication)))
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "XFR", fmt.Sprintf("SB %s CKI %s", getSwitchboardAddress(), sbctx.authentication)))
 }
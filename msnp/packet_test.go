@@ -0,0 +1,37 @@
+package msnp
+
+import (
+	"net"
+	"phantom/global"
+	"testing"
+	"time"
+)
+
+// a one-token line from a client that hasn't logged in is refused, not a crash
+func TestUnauthenticatedCommandWithoutTrId(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+
+	client := &global.Client{Connection: conn, Client: "MSN Messenger"}
+	ctx := &msnp_context{dispatched: true}
+
+	for _, line := range []string{"FOO", "SYN", "CHG 3 NLN"} {
+		go handleClientIncomingPackets(client, ctx, line)
+
+		remote.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 64)
+		n, err := remote.Read(buf)
+		if err != nil {
+			t.Fatalf("%q: no reply: %s", line, err.Error())
+		}
+
+		want := "302 0\r\n"
+		if line == "CHG 3 NLN" {
+			want = "302 3\r\n"
+		}
+		if string(buf[:n]) != want {
+			t.Errorf("%q: reply = %q, want %q", line, buf[:n], want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package msnp
+
+import (
+	"fmt"
+	"net"
+	"phantom/global"
+	"phantom/util"
+	"time"
+)
+
+// PNG has no trid, the reply is a bare QNG which carries the seconds until the next ping from MSNP9 on
+func handleClientPacketPing(client *global.Client) {
+	util.WriteTraffic(client.Connection, buildPingReply(getProtocolVersion(client), util.GetMSNPPingInterval()))
+}
+
+func buildPingReply(version int, interval int) string {
+	if version >= msnp_version_qng {
+		return fmt.Sprintf("QNG %d\r\n", interval)
+	}
+	return "QNG\r\n"
+}
+
+// closes the connection once nothing arrived for the configured idle timeout, any command counts as activity.
+// closing makes the pending read fail, so the session is torn down like any other disconnect
+func handleNotificationWatchdog(conn net.Conn, timeout time.Duration, activity chan struct{}, done chan struct{}) {
+	if timeout <= 0 {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-activity:
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(timeout)
+		case <-timer.C:
+			util.Log("MSN Messenger", "Client from %s stopped pinging, closing connection...", conn.RemoteAddr().String())
+			conn.Close()
+			return
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package msnp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBuildPingReply(t *testing.T) {
+	tests := []struct {
+		version int
+		want    string
+	}{
+		{msnp_version_qng - 1, "QNG\r\n"},
+		{msnp_version_qng, "QNG 50\r\n"},
+		{msnp_max_version, "QNG 50\r\n"},
+	}
+
+	for _, test := range tests {
+		if got := buildPingReply(test.version, 50); got != test.want {
+			t.Errorf("MSNP%d: reply = %q, want %q", test.version, got, test.want)
+		}
+	}
+}
+
+// reads until the watchdog closes the pipe, or gives up after the deadline
+func waitForClose(remote net.Conn, deadline time.Duration) bool {
+	remote.SetReadDeadline(time.Now().Add(deadline))
+	_, err := remote.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func TestNotificationWatchdog(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer remote.Close()
+
+	activity := make(chan struct{}, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go handleNotificationWatchdog(conn, 100*time.Millisecond, activity, done)
+
+	// pings keep the session alive past the timeout
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		activity <- struct{}{}
+	}
+	if waitForClose(remote, 10*time.Millisecond) {
+		t.Fatalf("connection closed while the client was pinging")
+	}
+
+	if !waitForClose(remote, time.Second) {
+		t.Errorf("idle connection was not closed")
+	}
+}
+
+func TestNotificationWatchdogStops(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+
+	done := make(chan struct{})
+	go handleNotificationWatchdog(conn, 50*time.Millisecond, make(chan struct{}, 1), done)
+	close(done)
+
+	if waitForClose(remote, 150*time.Millisecond) {
+		t.Errorf("watchdog closed the connection after the session ended")
+	}
+}
@@ -0,0 +1,180 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+)
+
+/*
+	NLN -> online
+	BSY -> busy
+	IDL -> idle
+	BRB -> be right back
+	AWY -> away
+	PHN -> on the phone
+	LUN -> out to lunch
+	HDN -> hidden, everyone else sees FLN
+*/
+
+var msnp_states = map[string]bool{
+	"NLN": true,
+	"BSY": true,
+	"IDL": true,
+	"BRB": true,
+	"AWY": true,
+	"PHN": true,
+	"LUN": true,
+	"HDN": true,
+}
+
+func getNotificationContext(email string) *msnp_context {
+	for i := 0; i < len(msn_context_list); i++ {
+		if msn_context_list[i].dispatched && msn_context_list[i].email == email {
+			return msn_context_list[i]
+		}
+	}
+	return nil
+}
+
+// BL always wins over AL, users on neither list are handled by the owner's BLP setting
+func isAllowedToSee(owner int, viewer int) bool {
+	if isOnList(owner, viewer, msnp_list_block) {
+		return false
+	}
+	if isOnList(owner, viewer, msnp_list_allow) {
+		return true
+	}
+
+	_, blp := getListSettings(owner)
+	return blp == msnp_list_allow
+}
+
+// what a single viewer gets to see of the user, an empty state means offline
+func visibleState(ctx *msnp_context, owner int, viewer int) string {
+	if ctx == nil || ctx.status == "" || ctx.status == "HDN" || !isAllowedToSee(owner, viewer) {
+		return ""
+	}
+	return ctx.status
+}
+
+// MSNP8+ watchers also get the client id (capabilities) of the user
+func buildPresenceArgs(target *global.Client, state string, from global.Account, fromctx *msnp_context) string {
+	args := fmt.Sprintf("%s %s %s", state, from.Email, url.PathEscape(from.Screenname))
+	if getProtocolVersion(target) >= msnp_version_twn {
+		clientid := "0"
+		if fromctx != nil && fromctx.clientid != "" {
+			clientid = fromctx.clientid
+		}
+		args += " " + clientid
+	}
+	return args
+}
+
+func sendPresence(target *global.Client, state string, from global.Account, fromctx *msnp_context) {
+	if state == "" {
+		util.WriteTraffic(target.Connection, fmt.Sprintf("FLN %s\r\n", from.Email))
+		return
+	}
+	util.WriteTraffic(target.Connection, fmt.Sprintf("NLN %s\r\n", buildPresenceArgs(target, state, from, fromctx)))
+}
+
+// tells everyone who has the user on their forward list about the user's state
+func handleClientBroadcastPresence(client *global.Client, ctx *msnp_context) {
+	watchers := getListEntries(client.Account.UserId, msnp_list_reverse)
+	for i := 0; i < len(watchers); i++ {
+		target := getNotificationClient(watchers[i].userid)
+		if target == nil {
+			continue
+		}
+		if wctx := getNotificationContext(target.Account.Email); wctx == nil || wctx.status == "" {
+			continue
+		}
+		if !isAllowedToSee(client.Account.UserId, watchers[i].userid) {
+			continue
+		}
+
+		sendPresence(target, visibleState(ctx, client.Account.UserId, watchers[i].userid), client.Account, ctx)
+	}
+}
+
+// resends the user's state to one watcher after a list change affected what they may see
+func handleClientRefreshPresence(client *global.Client, viewer int) {
+	target := getNotificationClient(viewer)
+	if target == nil || !isOnList(viewer, client.Account.UserId, msnp_list_forward) {
+		return
+	}
+	if wctx := getNotificationContext(target.Account.Email); wctx == nil || wctx.status == "" {
+		return
+	}
+
+	ctx := getNotificationContext(client.Account.Email)
+	sendPresence(target, visibleState(ctx, client.Account.UserId, viewer), client.Account, ctx)
+}
+
+// ILN for every online forward list contact, sent after the first CHG
+func handleClientInitialPresence(client *global.Client, data string) {
+	contacts := getListEntries(client.Account.UserId, msnp_list_forward)
+	for i := 0; i < len(contacts); i++ {
+		target := getNotificationClient(contacts[i].userid)
+		if target == nil {
+			continue
+		}
+
+		tctx := getNotificationContext(target.Account.Email)
+		state := visibleState(tctx, contacts[i].userid, client.Account.UserId)
+		if state == "" {
+			continue
+		}
+
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "ILN", buildPresenceArgs(client, state, target.Account, tctx)))
+	}
+}
+
+// CHG <trid> <state> [client id]
+func handleClientPacketChangeStatusRequest(client *global.Client, ctx *msnp_context, data string) {
+	state := findValueFromData("CHG", data, 1)
+	if !msnp_states[state] {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	first := ctx.status == ""
+	changed := ctx.status != state
+	ctx.status = state
+
+	if getProtocolVersion(client) >= msnp_version_twn {
+		ctx.clientid = findValueFromData("CHG", data, 2)
+		if ctx.clientid == "" {
+			ctx.clientid = "0"
+		}
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "CHG", state+" "+ctx.clientid))
+	} else {
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "CHG", state))
+	}
+
+	if first {
+		handleClientInitialPresence(client, data)
+
+		if getProtocolVersion(client) >= msnp_version_challenge {
+			sendChallenge(client, ctx)
+		}
+	}
+
+	// going from hidden to hidden (or the first CHG being HDN) has nothing to tell
+	if changed && !(first && state == "HDN") {
+		handleClientBroadcastPresence(client, ctx)
+	}
+}
+
+// FLN to everyone who could see the user before they left
+func handleClientBroadcastSignOut(client *global.Client, ctx *msnp_context) {
+	if client.Account.UserId == 0 || ctx.status == "" || ctx.status == "HDN" {
+		ctx.status = ""
+		return
+	}
+
+	ctx.status = ""
+	handleClientBroadcastPresence(client, ctx)
+}
@@ -0,0 +1,126 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+)
+
+/*
+	PRP phone number types
+
+	PHH -> home
+	PHW -> work
+	PHM -> mobile
+	MOB -> mobile device enabled (Y/N)
+	MBE -> mobile messages enabled (Y/N)
+*/
+
+const msnp_max_nick_length = 387
+
+var msnp_phone_types = map[string]bool{
+	"PHH": true,
+	"PHW": true,
+	"PHM": true,
+	"MOB": true,
+	"MBE": true,
+}
+
+type msnp_phone struct {
+	kind   string
+	number string
+}
+
+func getPhoneNumbers(uid int) []msnp_phone {
+	var phones []msnp_phone
+
+	res, err := util.GetDatabaseHandle().Query("SELECT type, number from msnphones WHERE id= ? ORDER BY type", uid)
+	if err != nil {
+		util.Error("MSNP -> getPhoneNumbers", err.Error())
+		return phones
+	}
+
+	for res.Next() {
+		var phone msnp_phone
+		res.Scan(&phone.kind, &phone.number)
+		phones = append(phones, phone)
+	}
+	res.Close()
+
+	return phones
+}
+
+// REA <trid> <email> <nick>, renaming yourself changes your screenname, renaming a contact only your view of them
+func handleClientPacketRenameRequest(client *global.Client, ctx *msnp_context, data string) {
+	mail := normalizeEmail(findValueFromData("REA", data, 1))
+	nick := findValueFromData("REA", data, 2)
+
+	name, err := url.PathUnescape(nick)
+	if err != nil || name == "" || len(nick) > msnp_max_nick_length {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "209"))
+		return
+	}
+
+	if mail == client.Account.Email {
+		if !queryList("UPDATE accounts SET screenname= ? WHERE id= ?", name, client.Account.UserId) {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+			return
+		}
+		client.Account.Screenname = name
+
+		version := global.GetListVersion(client.Account.UserId)
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "REA", fmt.Sprintf("%d %s %s", version, client.Account.Email, nick)))
+
+		if ctx.status != "" {
+			handleClientBroadcastPresence(client, ctx)
+		}
+		return
+	}
+
+	handleClientPacketRenameContact(client, data, mail, name)
+}
+
+// PRP <trid> <type> [number], leaving out the number clears it
+func handleClientPacketSetPhoneNumber(client *global.Client, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_phones) {
+		return
+	}
+
+	kind := findValueFromData("PRP", data, 1)
+	number := findValueFromData("PRP", data, 2)
+
+	if !msnp_phone_types[kind] || len(number) > 255 {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "201"))
+		return
+	}
+
+	uid := client.Account.UserId
+	ok := false
+	if number == "" {
+		ok = queryList("DELETE from msnphones WHERE id= ? AND type= ?", uid, kind)
+	} else {
+		ok = queryList("INSERT INTO msnphones (`id`, `type`, `number`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE number= VALUES(number)", uid, kind, number)
+	}
+	if !ok {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "500"))
+		return
+	}
+
+	version := global.IncrementListVersion(uid)
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "PRP", fmt.Sprintf("%d %s %s", version, kind, number)))
+
+	// everyone who has the user on their forward list keeps a copy of the numbers
+	watchers := getListEntries(uid, msnp_list_reverse)
+	for i := 0; i < len(watchers); i++ {
+		target := getNotificationClient(watchers[i].userid)
+		if target == nil || getProtocolVersion(target) < msnp_version_phones {
+			continue
+		}
+		if !isAllowedToSee(uid, watchers[i].userid) {
+			continue
+		}
+
+		util.WriteTraffic(target.Connection, fmt.Sprintf("BPR %d %s %s %s\r\n", global.IncrementListVersion(watchers[i].userid), client.Account.Email, kind, number))
+	}
+}
@@ -2,81 +2,114 @@ package msnp
 
 import (
 	"bytes"
+	"net"
 	"phantom/global"
 	"phantom/util"
 	"strings"
+	"time"
 )
 
+// unfinished commands kept across reads, a client that never completes one is dropped
+const msnp_max_pending = 64 * 1024
+
 func HandleNotification() {
 	tcpServer := util.CreateListener(1864)
 
 	for {
 		tcpClient, err := tcpServer.Accept()
+		if err != nil {
+			util.Error("MSNP -> HandleNotification", "Failed to accept Client! ", err.Error())
+			continue
+		}
 
-		go func() {
-			if err != nil {
-				util.Error("MSNP -> HandleNotification", "Failed to accept Client! ", err.Error())
-			} else {
-				util.Debug("MSNP -> HandleNotification", "Accepted Client")
-			}
+		util.Debug("MSNP -> HandleNotification", "Accepted Client")
+		go ServeNotification(tcpClient)
+	}
+}
 
-			util.Log("MSN Messenger", "Client awaiting authentication from %s", tcpClient.RemoteAddr().String())
+// ServeNotification runs a notification server session on any connection, the http gateway hands in its own
+func ServeNotification(conn net.Conn) {
+	util.Log("MSN Messenger", "Client awaiting authentication from %s", conn.RemoteAddr().String())
 
-			client := global.Client{
-				Connection: tcpClient,
-			}
+	client := global.Client{
+		Connection: conn,
+		Client:     "MSN Messenger",
+	}
 
-			global.AddClient(&client)
+	global.AddClient(&client)
 
-			ctx := msnp_context{
-				dispatched: true,
-				ctxkey:     generateContextKey(),
-			}
+	ctx := msnp_context{
+		dispatched: true,
+		ctxkey:     generateContextKey(),
+	}
 
-			addUserContext(&ctx)
+	addUserContext(&ctx)
 
-			for {
-				data, success := util.ReadTraffic(client.Connection)
+	activity := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go handleNotificationWatchdog(conn, time.Duration(util.GetMSNPIdleTimeout())*time.Second, activity, done)
 
-				recv := strings.Split(string(data), "\r\n")
+	// QRY carries a payload, so commands are framed instead of split on line breaks
+	var pending []byte
+	for {
+		data, success := util.ReadTraffic(client.Connection)
+		pending = append(pending, bytes.TrimRight(data, "\x00")...)
 
-				for ix := 0; ix < len(recv); ix++ {
-					recv[ix] = string(bytes.Trim([]byte(recv[ix]), "\x00"))
-					if recv[ix] != "" {
-						util.Debug("MSNP -> HandleNotification -> TCP", "Reading Split Data: %s", string(recv[ix]))
-						handleClientIncomingPackets(&client, &ctx, recv[ix])
-						//util.Debug("MSNP -> HandleNotification", "TCP dbg: %v", []byte(string(recv[ix])))
-					}
-				}
+		select {
+		case activity <- struct{}{}:
+		default:
+		}
 
-				if !success || handleClientLogoutRequest(string(data)) {
-					break
-				}
-			}
+		var frames []string
+		var ok bool
+		frames, pending, ok = splitCommandFrames(pending)
 
-			if client.Account.Email != "" {
-				util.Log("MSN Messenger", "Client Disconnected -> Email: %s", client.Account.Email)
-			} else {
-				util.Log("MSN Messenger", "Client Disconnected -> Email: Unknown")
-			}
+		if !ok || len(pending) > msnp_max_pending {
+			util.Log("MSN Messenger", "Client exceeded the command size limit -> Email: %s", client.Account.Email)
+			break
+		}
 
-			for i := 0; i < len(global.Clients); i++ {
-				if global.Clients[i].Account.Email == client.Account.Email {
-					util.Debug("MSNP -> HandleNotification", "Removing from clients from Clients List...")
-					global.Clients = global.RemoveClient(global.Clients, i)
-				}
+		logout := false
+		for ix := 0; ix < len(frames); ix++ {
+			util.Debug("MSNP -> HandleNotification -> TCP", "Reading Split Data: %s", frames[ix])
+			if handleClientLogoutRequest(frames[ix]) {
+				logout = true
+				break
 			}
+			handleClientIncomingPackets(&client, &ctx, frames[ix])
+		}
 
-			for ix := 0; ix < len(msn_context_list); ix++ {
-				if msn_context_list[ix].ctxkey == ctx.ctxkey {
-					util.Debug("MSNP -> HandleNotification", "Removing from clients from Context List...")
-					msn_context_list = removeUserContext(msn_context_list, ix)
-				}
-			}
+		if !success || logout {
+			break
+		}
+	}
+
+	close(done)
+	handleClientBroadcastSignOut(&client, &ctx)
+
+	if client.Account.Email != "" {
+		util.Log("MSN Messenger", "Client Disconnected -> Email: %s", client.Account.Email)
+	} else {
+		util.Log("MSN Messenger", "Client Disconnected -> Email: Unknown")
+	}
 
-			client.Connection.Close()
-		}()
+	global.ClientsLock.Lock()
+	for i := 0; i < len(global.Clients); i++ {
+		if global.Clients[i].Account.Email == client.Account.Email {
+			util.Debug("MSNP -> HandleNotification", "Removing from clients from Clients List...")
+			global.Clients = global.RemoveClient(global.Clients, i)
+		}
 	}
+	global.ClientsLock.Unlock()
+
+	for ix := 0; ix < len(msn_context_list); ix++ {
+		if msn_context_list[ix].ctxkey == ctx.ctxkey {
+			util.Debug("MSNP -> HandleNotification", "Removing from clients from Context List...")
+			msn_context_list = removeUserContext(msn_context_list, ix)
+		}
+	}
+
+	client.Connection.Close()
 }
 
 func HandleDispatch(client *global.Client, firstread string) {
@@ -123,12 +156,14 @@ func HandleDispatch(client *global.Client, firstread string) {
 		util.Log("MSN Messenger", "Client Disconnected (DS) -> Email: Unknown")
 	}
 
+	global.ClientsLock.Lock()
 	for i := 0; i < len(global.Clients); i++ {
 		if global.Clients[i].Account.Email == client.Account.Email {
 			util.Debug("MSNP -> HandleDispatch", "Removing from clients from Clients List...")
 			global.Clients = global.RemoveClient(global.Clients, i)
 		}
 	}
+	global.ClientsLock.Unlock()
 
 	for ix := 0; ix < len(msn_context_list); ix++ {
 		if msn_context_list[ix].ctxkey == ctx.ctxkey {
@@ -141,71 +176,75 @@ func HandleDispatch(client *global.Client, firstread string) {
 }
 
 func HandleSwitchboard() {
-	tcpServer := util.CreateListener(1865)
+	tcpServer := util.CreateListener(msnp_switchboard_port)
+
+	go handleSwitchboardCleanup()
 
 	for {
 		tcpClient, err := tcpServer.Accept()
+		if err != nil {
+			util.Error("MSNP -> HandleSwitchboard", "Failed to accept Client! ", err.Error())
+			continue
+		}
 
-		go func() {
-			if err != nil {
-				util.Error("MSNP -> HandleSwitchboard", "Failed to accept Client! ", err.Error())
-			} else {
-				util.Debug("MSNP -> HandleSwitchboard", "Accepted Client")
-			}
-
-			util.Log("MSN Messenger", "Client joining switchboard from %s", tcpClient.RemoteAddr().String())
+		util.Debug("MSNP -> HandleSwitchboard", "Accepted Client")
+		go ServeSwitchboard(tcpClient)
+	}
+}
 
-			data, _ := util.ReadTraffic(tcpClient)
+// ServeSwitchboard runs a switchboard session on any connection, the http gateway hands in its own
+func ServeSwitchboard(conn net.Conn) {
+	util.Log("MSN Messenger", "Client joining switchboard from %s", conn.RemoteAddr().String())
 
-			var ctx msnp_switchboard_context
-			mail := strings.Replace(findValueFromData("USR", string(data), 1), "@hotmail.com", util.GetMailDomain(), -1)
-			for i := 0; i < len(msn_switchboard_list); i++ {
-				if msn_switchboard_list[i].email == mail {
-					util.Debug("MSNP -> HandleSwitchboard", "Found Switchboard Context by Mail!")
-					ctx = *msn_switchboard_list[i]
-					ctx.connection = tcpClient
-				}
-			}
+	var ctx *msnp_switchboard_context
+	var pending []byte
+	disconnect := false
 
-			if !handleClientSwitchboardPacketAuthentication(&ctx, string(data)) {
-				util.Debug("MSNP -> HandleSwitchboard", "Failed to authenticate Switchboard session, closing...")
-				tcpClient.Close()
-				return
-			}
+	for !disconnect {
+		data, success := util.ReadTraffic(conn)
+		pending = append(pending, bytes.TrimRight(data, "\x00")...)
 
-			for {
-				data, success := util.ReadTraffic(ctx.connection)
+		var frames []string
+		var ok bool
+		frames, pending, ok = splitCommandFrames(pending)
 
-				recv := strings.Split(string(data), "\r\n")
+		if !ok || len(pending) > msnp_max_pending {
+			util.Log("MSN Messenger", "Client exceeded the command size limit on the switchboard from %s", conn.RemoteAddr().String())
+			break
+		}
 
-				for ix := 0; ix < len(recv); ix++ {
-					recv[ix] = string(bytes.Trim([]byte(recv[ix]), "\x00"))
-					if recv[ix] != "" {
-						util.Debug("MSNP -> HandleSwitchboard -> TCP", "Reading Split Data: %s", string(recv[ix]))
-						handleClientIncomingSwitchboardPackets(&ctx, recv[ix])
-						//util.Debug("MSNP -> HandleNotification", "TCP dbg: %v", []byte(string(recv[ix])))
-					}
-				}
+		for ix := 0; ix < len(frames) && !disconnect; ix++ {
+			util.Debug("MSNP -> HandleSwitchboard -> TCP", "Reading Split Data: %s", frames[ix])
 
-				if !success || handleClientLogoutRequest(string(data)) {
-					break
+			// the first command has to be USR or ANS
+			if ctx == nil {
+				ctx = handleClientSwitchboardPacketAuthentication(conn, frames[ix])
+				if ctx == nil {
+					util.Debug("MSNP -> HandleSwitchboard", "Failed to authenticate Switchboard session, closing...")
+					disconnect = true
 				}
+				continue
 			}
 
-			if ctx.email != "" {
-				util.Log("MSN Messenger", "Client Left Switchboard -> Email: %s", ctx.email)
-			} else {
-				util.Log("MSN Messenger", "Client Disconnected (SB) -> Email: Unknown")
+			if handleClientLogoutRequest(frames[ix]) {
+				disconnect = true
+				continue
 			}
 
-			for i := 0; i < len(msn_switchboard_list); i++ {
-				if msn_switchboard_list[i].email == ctx.email {
-					util.Debug("MSNP -> HandleSwitchboard", "Removing from clients from Context List...")
-					msn_switchboard_list = removeSwitchboardContext(msn_switchboard_list, i)
-				}
-			}
+			handleClientIncomingSwitchboardPackets(ctx, frames[ix])
+		}
+
+		if !success {
+			disconnect = true
+		}
+	}
 
-			ctx.connection.Close()
-		}()
+	if ctx != nil {
+		util.Log("MSN Messenger", "Client Left Switchboard -> Email: %s", ctx.email)
+		leaveSwitchboardSession(ctx)
+	} else {
+		util.Log("MSN Messenger", "Client Disconnected (SB) -> Email: Unknown")
 	}
+
+	conn.Close()
 }
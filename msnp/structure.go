@@ -2,6 +2,9 @@ package msnp
 
 import (
 	"net"
+	"phantom/global"
+	"sync"
+	"time"
 )
 
 type msnp_context struct {
@@ -10,6 +13,13 @@ type msnp_context struct {
 	email      string
 	authmethod string
 	status     string
+	challenge  string
+	clientid   string
+
+	// set once USR OK went out, everything but the login commands is refused before that
+	authenticated bool
+	// account named in USR TWN I, only taken over once the ticket checks out
+	pending global.Account
 }
 
 var msn_context_list []*msnp_context
@@ -22,13 +32,26 @@ type msnp_switchboard_context struct {
 	connection     net.Conn
 	nsinterface    net.Conn
 	nscontext      *msnp_context
+	session        *msnp_switchboard_session
+	created        time.Time
+	timedout       bool
+
+	// users of other protocols take part through a bridged context without a connection
+	bridged  bool
+	userid   int
+	bridgeto string
+	queued   []string
 }
 
 var msn_switchboard_list []*msnp_switchboard_context
 
 type msnp_switchboard_session struct {
-	sessionid int
-	clients   []*msnp_switchboard_context
+	sessionid    int
+	clients      []*msnp_switchboard_context
+	lastactivity time.Time
 }
 
 var msn_switchboard_sessions []*msnp_switchboard_session
+
+// guards msn_switchboard_list, msn_switchboard_sessions and the sessions' clients
+var switchboardLock sync.Mutex
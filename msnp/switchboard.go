@@ -2,6 +2,8 @@ package msnp
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"phantom/global"
 	"phantom/util"
 	"strconv"
@@ -9,116 +11,402 @@ import (
 	"time"
 )
 
+/*
+	switchboard flow
+
+	NS  XFR SB             -> cookie for the caller, USR on the switchboard opens a new session
+	SB  CAL <email>        -> RNG with a cookie is sent to the invitee's notification server
+	SB  ANS                -> invitee joins, gets IRO for everyone present, the others get JOI
+	SB  MSG <ack> <length> -> relayed to everyone else in the session
+	SB  OUT                -> others get BYE, the session is dropped once it's empty
+
+	MSG acknowledgement modes
+
+	U -> never acknowledged
+	N -> NAK on failure only
+	A -> ACK on success, NAK on failure
+	D -> same as A
+*/
+
+const (
+	msnp_switchboard_idle_timeout   = 5 * time.Minute
+	msnp_switchboard_invite_timeout = 2 * time.Minute
+	msnp_switchboard_cleanup        = time.Minute
+	msnp_switchboard_port           = 1865
+)
+
+// host:port handed out in XFR and RNG, the host comes from sbhost in the config
+func getSwitchboardAddress() string {
+	return net.JoinHostPort(util.GetSwitchboardHost(), strconv.Itoa(msnp_switchboard_port))
+}
+
 func handleClientIncomingSwitchboardPackets(ctx *msnp_switchboard_context, data string) {
 
 	switch {
 	case strings.HasPrefix(data, "CAL"):
 		handleClientSwitchboardPacketSendSwitchboardInvite(ctx, data)
+	case strings.HasPrefix(data, "MSG"):
+		handleClientSwitchboardPacketMessage(ctx, data)
 	}
 }
 
-func handleClientSwitchboardPacketAuthentication(ctx *msnp_switchboard_context, data string) bool {
-	if strings.HasPrefix(data, "USR") {
-		mail := strings.Replace(findValueFromData("USR", data, 1), "@hotmail.com", util.GetMailDomain(), -1)
-		auth := findValueFromData("USR", data, 2)
-		acc, _ := global.GetUserDataFromEmail(mail)
+// pending contexts are created by XFR and CAL and claimed by the first USR or ANS presenting their cookie
+func claimSwitchboardContext(conn net.Conn, email string, cookie string, sessionid int) *msnp_switchboard_context {
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
 
-		util.Debug("MSNP -> handleClientSwitchboardPacketAuthentication", "auth test1: %s", auth)
-		util.Debug("MSNP -> handleClientSwitchboardPacketAuthentication", "auth test2: %s", ctx.authentication)
+	for i := 0; i < len(msn_switchboard_list); i++ {
+		ctx := msn_switchboard_list[i]
+		if ctx.connection == nil && ctx.email == email && ctx.authentication == cookie && ctx.sessionid == sessionid {
+			ctx.connection = conn
+			return ctx
+		}
+	}
 
-		if ctx.authentication == auth {
-			ctx.email = mail
-			ctx.username = acc.Screenname
+	return nil
+}
 
-			util.WriteTraffic(ctx.connection, msnp_new_command(data, "USR", fmt.Sprintf("OK %s %s", mail, ctx.username)))
-			return true
-		} else {
-			util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "911"))
-			return false
+func createSwitchboardSession(ctx *msnp_switchboard_context) {
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
+
+	sessionid := generateContextKey()
+	for i := 0; i < len(msn_switchboard_sessions); i++ {
+		if msn_switchboard_sessions[i].sessionid == sessionid {
+			sessionid = generateContextKey()
+			i = -1
 		}
-	} else { //[Debug] [TCP -> ReadTraffic] Reading Data: ANS 1 test2@hotmail.com 1843e8b2e6b 31847
-		mail := strings.Replace(findValueFromData("ANS", data, 1), "@hotmail.com", util.GetMailDomain(), -1)
-		authenticate := findValueFromData("ANS", data, 2)
-		sessionid, _ := strconv.Atoi(findValueFromData("ANS", data, 3))
+	}
 
-		if authenticate != ctx.authentication {
-			util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "911"))
-			return false
+	session := msnp_switchboard_session{
+		sessionid:    sessionid,
+		clients:      []*msnp_switchboard_context{ctx},
+		lastactivity: time.Now(),
+	}
+
+	ctx.sessionid = sessionid
+	ctx.session = &session
+	addSwitchboardSession(&session)
+}
+
+func getSwitchboardSession(sessionid int) *msnp_switchboard_session {
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
+
+	for i := 0; i < len(msn_switchboard_sessions); i++ {
+		if msn_switchboard_sessions[i].sessionid == sessionid {
+			return msn_switchboard_sessions[i]
 		}
+	}
 
-		for i := 0; i < len(msn_switchboard_sessions); i++ {
-			if msn_switchboard_sessions[i] != nil {
-				if msn_switchboard_sessions[i].sessionid == sessionid {
-					msn_switchboard_sessions[i].clients = append(msn_switchboard_sessions[i].clients, ctx)
-				}
+	return nil
+}
 
-				if msn_switchboard_sessions[i].clients[i].email != mail {
-					util.WriteTraffic(ctx.connection, msnp_new_command("IRO", data, fmt.Sprintf("")))
-				}
-			}
+// everyone in the session except the given context
+func getSwitchboardParticipants(ctx *msnp_switchboard_context) []*msnp_switchboard_context {
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
+
+	var participants []*msnp_switchboard_context
+	if ctx.session == nil {
+		return participants
+	}
+
+	for i := 0; i < len(ctx.session.clients); i++ {
+		if ctx.session.clients[i] != ctx {
+			participants = append(participants, ctx.session.clients[i])
+		}
+	}
+
+	return participants
+}
+
+func isInSwitchboardSession(session *msnp_switchboard_session, email string) bool {
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
+
+	for i := 0; i < len(session.clients); i++ {
+		if session.clients[i].email == email {
+			return true
 		}
 	}
 
 	return false
 }
 
-// todo
-func handleClientSwitchboardPacketSendSwitchboardInvite(ctx *msnp_switchboard_context, data string) {
+// returns false when at least one participant could not be reached
+func broadcastSwitchboard(ctx *msnp_switchboard_context, data string) bool {
+	participants := getSwitchboardParticipants(ctx)
+	if len(participants) == 0 {
+		return false
+	}
 
-	mail := strings.Replace(findValueFromData("CAL", data, 1), "@hotmail.com", util.GetMailDomain(), -1)
-	var cl *msnp_context
-	var cx *global.Client
+	delivered := true
+	for i := 0; i < len(participants); i++ {
+		if participants[i].bridged {
+			continue
+		}
+		if util.WriteTraffic(participants[i].connection, data) != nil {
+			delivered = false
+		}
+	}
 
-	for i := 0; i < len(global.Clients); i++ {
-		if global.Clients[i] != nil {
-			if global.Clients[i].Account.Email == mail {
-				cx = global.Clients[i]
-			}
+	switchboardLock.Lock()
+	ctx.session.lastactivity = time.Now()
+	switchboardLock.Unlock()
+
+	return delivered
+}
+
+// USR <trid> <email> <cookie> or ANS <trid> <email> <cookie> <sessionid>
+func handleClientSwitchboardPacketAuthentication(conn net.Conn, data string) *msnp_switchboard_context {
+	if strings.HasPrefix(data, "USR") {
+		mail := normalizeEmail(findValueFromData("USR", data, 1))
+		ctx := claimSwitchboardContext(conn, mail, findValueFromData("USR", data, 2), 0)
+		if ctx == nil {
+			util.WriteTraffic(conn, msnp_new_command_noargs(data, "911"))
+			return nil
 		}
+
+		createSwitchboardSession(ctx)
+
+		util.WriteTraffic(conn, msnp_new_command(data, "USR", fmt.Sprintf("OK %s %s", ctx.email, url.PathEscape(ctx.username))))
+		return ctx
 	}
 
-	for i := 0; i < len(msn_context_list); i++ {
-		if msn_context_list[i] != nil {
-			if msn_context_list[i].email == cx.Account.Email {
-				cl = msn_context_list[i]
+	if strings.HasPrefix(data, "ANS") {
+		mail := normalizeEmail(findValueFromData("ANS", data, 1))
+		sessionid, _ := strconv.Atoi(findValueFromData("ANS", data, 3))
+
+		ctx := claimSwitchboardContext(conn, mail, findValueFromData("ANS", data, 2), sessionid)
+		session := getSwitchboardSession(sessionid)
+		if ctx == nil || session == nil {
+			util.WriteTraffic(conn, msnp_new_command_noargs(data, "911"))
+			return nil
+		}
+
+		switchboardLock.Lock()
+		participants := make([]*msnp_switchboard_context, len(session.clients))
+		copy(participants, session.clients)
+		session.clients = append(session.clients, ctx)
+		session.lastactivity = time.Now()
+		ctx.session = session
+		switchboardLock.Unlock()
+
+		for i := 0; i < len(participants); i++ {
+			util.WriteTraffic(conn, msnp_new_command(data, "IRO", fmt.Sprintf("%d %d %s %s", i+1, len(participants), participants[i].email, url.PathEscape(participants[i].username))))
+		}
+		util.WriteTraffic(conn, msnp_new_command(data, "ANS", "OK"))
+
+		broadcastSwitchboard(ctx, fmt.Sprintf("JOI %s %s\r\n", ctx.email, url.PathEscape(ctx.username)))
+
+		// messages from other protocols that rang this session were held until now
+		var queued []string
+		switchboardLock.Lock()
+		for i := 0; i < len(participants); i++ {
+			if participants[i].bridged {
+				queued = append(queued, participants[i].queued...)
+				participants[i].queued = nil
 			}
 		}
+		switchboardLock.Unlock()
+
+		for i := 0; i < len(queued); i++ {
+			util.WriteTraffic(conn, queued[i])
+		}
+		return ctx
+	}
+
+	util.WriteTraffic(conn, msnp_new_command_noargs(data, "911"))
+	return nil
+}
+
+// CAL <trid> <email>
+func handleClientSwitchboardPacketSendSwitchboardInvite(ctx *msnp_switchboard_context, data string) {
+	mail := normalizeEmail(findValueFromData("CAL", data, 1))
+
+	acc, _ := global.GetUserDataFromEmail(mail)
+	if acc.UserId == 0 {
+		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "208"))
+		return
 	}
 
-	if cl.status == "HDN" {
+	if isInSwitchboardSession(ctx.session, acc.Email) {
+		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "215"))
+		return
+	}
+
+	// hidden users and users blocking the caller look offline
+	caller, _ := global.GetUserDataFromEmail(ctx.email)
+	target := getNotificationClient(acc.UserId)
+	if target == nil || visibleState(getNotificationContext(acc.Email), acc.UserId, caller.UserId) == "" {
+		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "217"))
+		return
+	}
+
+	if ringSwitchboard(ctx.sessionid, target, ctx.email, ctx.username) != nil {
 		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "217"))
 		return
 	}
 
-	ctx.sessionid = generateContextKey() // generate random int
 	util.WriteTraffic(ctx.connection, msnp_new_command(data, "CAL", fmt.Sprintf("RINGING %d", ctx.sessionid)))
+}
 
-	date := time.Now().UTC().UnixMilli()
+// leaves a pending context for the target and sends them RNG, ANS with the cookie claims it
+func ringSwitchboard(sessionid int, target *global.Client, email string, username string) error {
 	sbctx := msnp_switchboard_context{
-		sessionid:      ctx.sessionid,
-		username:       cx.Account.Screenname,
-		email:          cx.Account.Email,
-		authentication: strconv.FormatInt(date, 16),
+		sessionid:      sessionid,
+		username:       target.Account.Screenname,
+		email:          target.Account.Email,
+		authentication: generateCookie(),
+		nsinterface:    target.Connection,
+		nscontext:      getNotificationContext(target.Account.Email),
+		created:        time.Now(),
 	}
+
+	switchboardLock.Lock()
 	addSwitchboardContext(&sbctx)
+	switchboardLock.Unlock()
 
-	for i := 0; i < len(msn_switchboard_sessions); i++ {
-		if msn_switchboard_sessions[i] != nil {
-			if msn_switchboard_sessions[i].sessionid != ctx.sessionid {
-				sbsess := msnp_switchboard_session{
-					sessionid: ctx.sessionid,
-				}
+	return util.WriteTraffic(target.Connection, fmt.Sprintf("RNG %d %s CKI %s %s %s\r\n", sessionid, getSwitchboardAddress(), sbctx.authentication, email, url.PathEscape(username)))
+}
+
+// MSG <trid> <ack> <length>\r\n<payload>
+func handleClientSwitchboardPacketMessage(ctx *msnp_switchboard_context, data string) {
+	header, payload := splitPayload(data)
+	ack := findValueFromData("MSG", header, 1)
+
+	msg, ok := parseMimeMessage(payload)
+	if !ok {
+		util.Debug("MSNP -> handleClientSwitchboardPacketMessage", "Dropping malformed message from %s", ctx.email)
+		if ack == "N" || ack == "A" || ack == "D" {
+			util.WriteTraffic(ctx.connection, msnp_new_command_noargs(header, "NAK"))
+		}
+		return
+	}
+
+	switch msg.kind() {
+	case msnp_message_text:
+		util.Debug("MSNP -> handleClientSwitchboardPacketMessage", "Text message from %s in session %d", ctx.email, ctx.sessionid)
+	case msnp_message_typing:
+		// nobody gets to type in someone else's name
+		msg.set("TypingUser", ctx.email)
+		payload = msg.build()
+	case msnp_message_invite:
+		util.Debug("MSNP -> handleClientSwitchboardPacketMessage", "Invitation (%s) from %s in session %d", msg.get("Invitation-Command"), ctx.email, ctx.sessionid)
+	default:
+		util.Debug("MSNP -> handleClientSwitchboardPacketMessage", "Relaying unknown message type %s from %s", msg.contentType(), ctx.email)
+	}
+
+	delivered := broadcastSwitchboard(ctx, fmt.Sprintf("MSG %s %s %d\r\n%s", ctx.email, url.PathEscape(ctx.username), len(payload), payload))
+	if !deliverBridgedMessage(ctx, msg) {
+		delivered = false
+	}
+
+	switch {
+	case !delivered && (ack == "N" || ack == "A" || ack == "D"):
+		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(header, "NAK"))
+	case delivered && (ack == "A" || ack == "D"):
+		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(header, "ACK"))
+	}
+}
+
+// drops the context and tells the others, sessions go away with their last participant
+func leaveSwitchboardSession(ctx *msnp_switchboard_context) {
+	switchboardLock.Lock()
+	idle := ctx.timedout
+	switchboardLock.Unlock()
+
+	if ctx.session != nil {
+		bye := fmt.Sprintf("BYE %s\r\n", ctx.email)
+		if idle {
+			bye = fmt.Sprintf("BYE %s 1\r\n", ctx.email)
+		}
+		broadcastSwitchboard(ctx, bye)
+	}
+
+	switchboardLock.Lock()
+	defer switchboardLock.Unlock()
 
-				sbsess.clients = append(sbsess.clients, ctx)
-				addSwitchboardSession(&sbsess)
+	for i := 0; i < len(msn_switchboard_list); i++ {
+		if msn_switchboard_list[i] == ctx {
+			msn_switchboard_list = removeSwitchboardContext(msn_switchboard_list, i)
+			break
+		}
+	}
+
+	if ctx.session == nil {
+		return
+	}
+
+	for i := 0; i < len(ctx.session.clients); i++ {
+		if ctx.session.clients[i] == ctx {
+			ctx.session.clients = append(ctx.session.clients[:i], ctx.session.clients[i+1:]...)
+			break
+		}
+	}
+
+	// bridged participants can't keep a session alive on their own
+	if !hasConnectedParticipants(ctx.session) {
+		for i := 0; i < len(msn_switchboard_sessions); i++ {
+			if msn_switchboard_sessions[i] == ctx.session {
+				msn_switchboard_sessions = removeSwitchboardSession(msn_switchboard_sessions, i)
 				break
 			}
 		}
 	}
+}
 
-	err := util.WriteTraffic(cx.Connection, fmt.Sprintf("RNG %d %s:1865 CKI %s %s %s\r\n", ctx.sessionid, util.GetRootUrl(), strconv.FormatInt(date, 16), ctx.email, ctx.username))
-	if err != nil {
-		util.WriteTraffic(ctx.connection, msnp_new_command_noargs(data, "217"))
-		return
+// switchboardLock has to be held
+func hasConnectedParticipants(session *msnp_switchboard_session) bool {
+	for i := 0; i < len(session.clients); i++ {
+		if !session.clients[i].bridged {
+			return true
+		}
+	}
+	return false
+}
+
+// closes idle sessions and forgets invitations nobody answered
+func handleSwitchboardCleanup() {
+	for {
+		time.Sleep(msnp_switchboard_cleanup)
+
+		var idle []net.Conn
+
+		switchboardLock.Lock()
+		for i := 0; i < len(msn_switchboard_list); i++ {
+			if msn_switchboard_list[i].connection == nil && time.Since(msn_switchboard_list[i].created) > msnp_switchboard_invite_timeout {
+				msn_switchboard_list = removeSwitchboardContext(msn_switchboard_list, i)
+				i--
+			}
+		}
+		for i := 0; i < len(msn_switchboard_sessions); i++ {
+			session := msn_switchboard_sessions[i]
+
+			// bridged sessions whose invitation was never answered
+			if !hasConnectedParticipants(session) && time.Since(session.lastactivity) > msnp_switchboard_invite_timeout {
+				msn_switchboard_sessions = removeSwitchboardSession(msn_switchboard_sessions, i)
+				i--
+				continue
+			}
+
+			if time.Since(session.lastactivity) > msnp_switchboard_idle_timeout {
+				for ix := 0; ix < len(session.clients); ix++ {
+					if session.clients[ix].bridged {
+						continue
+					}
+					session.clients[ix].timedout = true
+					idle = append(idle, session.clients[ix].connection)
+				}
+			}
+		}
+		switchboardLock.Unlock()
+
+		// the read loops notice the closed connection and leave their sessions
+		for i := 0; i < len(idle); i++ {
+			util.Debug("MSNP -> handleSwitchboardCleanup", "Closing idle switchboard connection %s", idle[i].RemoteAddr().String())
+			idle[i].Close()
+		}
 	}
 }
@@ -0,0 +1,65 @@
+package msnp
+
+import (
+	"fmt"
+	"net/url"
+	"phantom/global"
+	"phantom/util"
+	"time"
+)
+
+/*
+	MSNP8+ login
+
+	USR <trid> TWN I <email>       -> USR <trid> TWN S <policy>
+	client gets a "passport" token from the login in http/passport.go
+	USR <trid> TWN S t=<ticket>&p=<profile> -> USR <trid> OK <email> <nick> 1 0
+*/
+
+const msnp_twn_policy = "lc=1033,id=507,tw=40,fs=1,ru=http%%3A%%2F%%2Fmessenger%%2Emsn%%2Ecom,ct=%d,kpp=1,kv=5,ver=2.1.0173.1,tpf=%s"
+
+func handleClientPacketTweenerAuthentication(client *global.Client, ctx *msnp_context, data string) {
+	if getProtocolVersion(client) < msnp_version_twn {
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "911"))
+		return
+	}
+
+	switch findValueFromData("USR", data, 2) {
+	case "I":
+		account := normalizeEmail(findValueFromData("USR", data, 3))
+		acc, _ := global.GetUserDataFromEmail(account)
+		if acc.UserId == 0 {
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "911"))
+			return
+		}
+
+		ctx.pending = acc
+
+		now := time.Now().Unix()
+		policy := fmt.Sprintf(msnp_twn_policy, now, util.HashMD5(fmt.Sprintf("%s%d", account, now)))
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "USR", "TWN S "+policy))
+	case "S":
+		values, _ := url.ParseQuery(findValueFromData("USR", data, 3))
+		ticket := values.Get("t")
+
+		uid, ok := global.LookupToken("passport", ticket)
+		if !ok || ctx.pending.UserId == 0 || uid != ctx.pending.UserId {
+			util.Log("MSN Messenger", "Rejected passport ticket -> Email: %s", ctx.pending.Email)
+			util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "911"))
+			return
+		}
+
+		// tickets are single use
+		global.RevokeToken(ticket)
+
+		ctx.email = ctx.pending.Email
+		ctx.authenticated = true
+		client.Account = ctx.pending
+		ctx.pending = global.Account{}
+
+		util.WriteTraffic(client.Connection, msnp_new_command(data, "USR", fmt.Sprintf("OK %s %s 1 0", client.Account.Email, url.PathEscape(client.Account.Screenname))))
+		sendMailboxStatus(client)
+	default:
+		util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "911"))
+	}
+}
@@ -0,0 +1,37 @@
+package msnp
+
+import (
+	"fmt"
+	"phantom/global"
+	"phantom/util"
+	"strings"
+	"time"
+)
+
+const msnp_url_token_ttl = time.Hour
+
+// URL services are served by the local web pages, anything unknown lands on the home page
+var msnp_service_urls = map[string]string{
+	"INBOX":   msnp_mail_path,
+	"FOLDERS": msnp_mail_path,
+	"COMPOSE": msnp_mail_path,
+	"PROFILE": "/profile/edit",
+	"PERSON":  "/profile/edit",
+}
+
+// URL <trid> <service> [parameter]
+func handleClientPacketServiceUrl(client *global.Client, data string) {
+	if !requireProtocolVersion(client, data, msnp_version_url) {
+		return
+	}
+
+	path, ok := msnp_service_urls[strings.ToUpper(findValueFromData("URL", data, 1))]
+	if !ok {
+		path = "/home"
+	}
+
+	token := global.IssueToken("web", client.Account.UserId, msnp_url_token_ttl)
+	link := fmt.Sprintf("http://%s%s?token=%s", util.GetRootUrl(), path, token)
+
+	util.WriteTraffic(client.Connection, msnp_new_command(data, "URL", fmt.Sprintf("%s %s 2", path, link)))
+}
@@ -0,0 +1,40 @@
+package msnp
+
+import (
+	"phantom/global"
+	"phantom/util"
+)
+
+/*
+	first protocol version each command is available in
+
+	REA             -> MSNP2
+	PRP/BPR         -> MSNP5
+	URL             -> MSNP6
+	CHL/QRY         -> MSNP6
+	ADG/RMG/REG/LSG -> MSNP7
+	USR TWN         -> MSNP8, along with the new SYN/LST format and client ids in presence
+	QNG <interval>  -> MSNP9
+*/
+
+const (
+	msnp_version_phones    = 5
+	msnp_version_url       = 6
+	msnp_version_challenge = 6
+	msnp_version_groups    = 7
+	msnp_version_twn       = 8
+	msnp_version_qng       = 9
+)
+
+const msnp_max_version = 9
+
+// answers commands newer than the negotiated protocol with a syntax error
+func requireProtocolVersion(client *global.Client, data string, version int) bool {
+	if getProtocolVersion(client) >= version {
+		return true
+	}
+
+	util.Debug("MSNP -> requireProtocolVersion", "%s needs MSNP%d, client negotiated %s", data, version, client.Protocol)
+	util.WriteTraffic(client.Connection, msnp_new_command_noargs(data, "200"))
+	return false
+}
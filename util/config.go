@@ -3,6 +3,8 @@ package util
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 )
 
@@ -24,6 +26,15 @@ func readJsonConfig() map[string]interface{} {
 	return payload
 }
 
+func getConfigInt(key string, fallback int) int {
+	value, ok := readJsonConfig()[key].(float64)
+	if !ok {
+		return fallback
+	}
+
+	return int(value)
+}
+
 func GetRootUrl() string {
 	return fmt.Sprintf("%s", readJsonConfig()["root"])
 }
@@ -49,3 +60,90 @@ func GetServiceEnabled(service string) bool {
 		return false
 	}
 }
+
+// maximum amount of pending offline messages per recipient, 0 disables the quota
+func GetOfflineMessageQuota() int {
+	return getConfigInt("offlinequota", 100)
+}
+
+// days until an undelivered offline message is dropped, 0 keeps them forever
+func GetOfflineMessageExpiry() int {
+	return getConfigInt("offlineexpiry", 30)
+}
+
+// account server notices like offline message receipts are sent from, 0 turns those notices off
+func GetSystemUserId() int {
+	return getConfigInt("systemuid", 0)
+}
+
+// seconds msnp clients are told to wait between PNGs
+func GetMSNPPingInterval() int {
+	return getConfigInt("msnppinginterval", 50)
+}
+
+// seconds without any traffic before a notification server session is dropped, 0 disables the check
+func GetMSNPIdleTimeout() int {
+	return getConfigInt("msnpidletimeout", 300)
+}
+
+// host or ip msnp clients are sent to for switchboard sessions, without scheme or port
+// falls back to the host part of root for configs written before sbhost existed
+func GetSwitchboardHost() string {
+	if host, _ := readJsonConfig()["sbhost"].(string); host != "" {
+		return host
+	}
+
+	root := GetRootUrl()
+	if parsed, err := url.Parse(root); err == nil && parsed.Hostname() != "" {
+		return parsed.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(root); err == nil {
+		return host
+	}
+	return root
+}
+
+// key required by the admin api, an empty key disables it
+func GetAdminKey() string {
+	key, _ := readJsonConfig()["adminkey"].(string)
+	return key
+}
+
+// certificate and key for the https listener, empty when https is disabled
+func GetTLSConfig() (string, string, int) {
+	cert, _ := readJsonConfig()["tlscert"].(string)
+	key, _ := readJsonConfig()["tlskey"].(string)
+	return cert, key, getConfigInt("tlsport", 443)
+}
+
+// link id -> url template, see msim/netlink.go for the placeholders
+func GetNetLinks() map[string]string {
+	links := map[string]string{}
+
+	table, ok := readJsonConfig()["netlinks"].(map[string]interface{})
+	if !ok {
+		return links
+	}
+
+	for id, url := range table {
+		links[id] = fmt.Sprintf("%s", url)
+	}
+
+	return links
+}
+
+// msnp client id -> challenge key, see msnp/challenge.go for the defaults
+func GetMSNPClientKeys() map[string]string {
+	keys := map[string]string{}
+
+	table, ok := readJsonConfig()["msnpclients"].(map[string]interface{})
+	if !ok {
+		return keys
+	}
+
+	for id, key := range table {
+		keys[id] = fmt.Sprintf("%s", key)
+	}
+
+	return keys
+}
@@ -18,6 +18,111 @@ func InitDatabase() {
 	database.SetMaxOpenConns(100)
 	database.SetMaxIdleConns(50)
 	Log("Database", "Initialised database server")
+
+	migrateDatabase()
+}
+
+/*
+	databases created from an older example.database.sql are brought up to date on start
+
+	tables are created when missing, columns are added when missing. both
+	follow example.database.sql, which stays the reference for fresh installs
+*/
+
+var database_tables = []string{
+	"CREATE TABLE IF NOT EXISTS `actions` (`id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, `from_id` int(11) NOT NULL, `to_id` int(11) NOT NULL, `type` int(11) NOT NULL, `zap` int(11) NOT NULL, `date` bigint(30) NOT NULL) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `bulletinqueue` (`bulletin_id` int(11) NOT NULL, `to_id` int(11) NOT NULL, `notified` tinyint(1) NOT NULL DEFAULT 0, PRIMARY KEY (`bulletin_id`,`to_id`), KEY `to_id` (`to_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `bulletins` (`id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, `from_id` int(11) NOT NULL, `subject` varchar(255) NOT NULL, `body` text NOT NULL, `date` bigint(30) NOT NULL, KEY `from_id` (`from_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `contactinfo` (`owner_id` int(11) NOT NULL, `contact_id` int(11) NOT NULL, `nickname` varchar(255) NOT NULL DEFAULT '', `notes` text NOT NULL, `nameselect` int(11) NOT NULL DEFAULT 0, PRIMARY KEY (`owner_id`,`contact_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `directory` (`id` int(11) NOT NULL PRIMARY KEY, `fname` varchar(64) NOT NULL DEFAULT '', `lname` varchar(64) NOT NULL DEFAULT '', `city` varchar(64) NOT NULL DEFAULT '', `state` varchar(64) NOT NULL DEFAULT '', `country` varchar(64) NOT NULL DEFAULT '', `listed` tinyint(1) NOT NULL DEFAULT 0, KEY `name` (`lname`,`fname`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `mailbox` (`id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, `to_id` int(11) NOT NULL, `from_name` varchar(255) NOT NULL, `from_addr` varchar(255) NOT NULL, `subject` varchar(255) NOT NULL, `body` text NOT NULL, `date` bigint(20) NOT NULL, `seen` tinyint(1) NOT NULL DEFAULT 0, KEY `to_id` (`to_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `msngroupmembers` (`owner_id` int(11) NOT NULL, `contact_id` int(11) NOT NULL, `groupid` int(11) NOT NULL, PRIMARY KEY (`owner_id`,`contact_id`,`groupid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `msngroups` (`owner_id` int(11) NOT NULL, `groupid` int(11) NOT NULL, `name` varchar(255) NOT NULL, PRIMARY KEY (`owner_id`,`groupid`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `msnlists` (`owner_id` int(11) NOT NULL, `contact_id` int(11) NOT NULL, `list` char(2) NOT NULL, PRIMARY KEY (`owner_id`,`contact_id`,`list`), KEY `contact_id` (`contact_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `msnphones` (`id` int(11) NOT NULL, `type` char(3) NOT NULL, `number` varchar(255) NOT NULL, PRIMARY KEY (`id`,`type`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `offlinereceipts` (`id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY, `from_id` int(11) NOT NULL, `to_id` int(11) NOT NULL, `date` bigint(30) NOT NULL, KEY `from_id` (`from_id`)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `presence` (`id` int(11) NOT NULL PRIMARY KEY, `status` int(11) NOT NULL DEFAULT 0, `statustext` varchar(255) NOT NULL DEFAULT '', `awaymsg` varchar(255) NOT NULL DEFAULT '', `idlesince` bigint(20) NOT NULL DEFAULT 0, `lastchange` bigint(20) NOT NULL DEFAULT 0) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+	"CREATE TABLE IF NOT EXISTS `privacy` (`id` int(11) NOT NULL PRIMARY KEY, `searchable` tinyint(1) NOT NULL DEFAULT 1, `privacymode` int(11) NOT NULL DEFAULT 0, `showavatar` tinyint(1) NOT NULL DEFAULT 1) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4",
+}
+
+type database_column struct {
+	table  string
+	column string
+	alter  string
+}
+
+var database_columns = []database_column{
+	// offline messages are deleted one by one after delivery, so they need an id
+	{"offlinemsgs", "id", "ALTER TABLE `offlinemsgs` ADD `id` int(11) NOT NULL AUTO_INCREMENT PRIMARY KEY FIRST, ADD KEY `to_id` (`to_id`)"},
+	{"msn", "gtc", "ALTER TABLE `msn` ADD `gtc` char(1) NOT NULL DEFAULT 'A'"},
+	{"msn", "blp", "ALTER TABLE `msn` ADD `blp` char(2) NOT NULL DEFAULT 'AL'"},
+}
+
+func hasColumn(table string, column string) (bool, error) {
+	var count int
+
+	row, err := db.Query("SELECT COUNT(*) from information_schema.columns WHERE table_schema= DATABASE() AND table_name= ? AND column_name= ?", table, column)
+	if err != nil {
+		return false, err
+	}
+	if row.Next() {
+		row.Scan(&count)
+	}
+	row.Close()
+
+	return count > 0, nil
+}
+
+func hasPrimaryKey(table string) (bool, error) {
+	var count int
+
+	row, err := db.Query("SELECT COUNT(*) from information_schema.statistics WHERE table_schema= DATABASE() AND table_name= ? AND index_name= 'PRIMARY'", table)
+	if err != nil {
+		return false, err
+	}
+	if row.Next() {
+		row.Scan(&count)
+	}
+	row.Close()
+
+	return count > 0, nil
+}
+
+func migrateDatabase() {
+	for i := 0; i < len(database_tables); i++ {
+		if _, err := db.Exec(database_tables[i]); err != nil {
+			Error("Database", "Failed to create table: %s", err.Error())
+		}
+	}
+
+	for i := 0; i < len(database_columns); i++ {
+		migration := database_columns[i]
+
+		found, err := hasColumn(migration.table, migration.column)
+		if err != nil {
+			Error("Database", "Failed to check %s.%s: %s", migration.table, migration.column, err.Error())
+			continue
+		}
+		if found {
+			continue
+		}
+
+		Log("Database", "Adding %s column to %s", migration.column, migration.table)
+		if _, err := db.Exec(migration.alter); err != nil {
+			Error("Database", "Failed to migrate %s.%s: %s", migration.table, migration.column, err.Error())
+		}
+	}
+
+	// the contact list version and msnp settings are upserted by id
+	found, err := hasPrimaryKey("msn")
+	if err != nil {
+		Error("Database", "Failed to check msn: %s", err.Error())
+	} else if !found {
+		Log("Database", "Adding primary key to msn")
+		if _, err := db.Exec("ALTER TABLE `msn` ADD PRIMARY KEY (`id`)"); err != nil {
+			Error("Database", "Failed to migrate msn: %s", err.Error())
+		}
+	}
 }
 
 func GetDatabaseHandle() *sql.DB {
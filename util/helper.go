@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
@@ -8,6 +9,11 @@ import (
 	"crypto/rc4"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 
 	"golang.org/x/text/encoding/unicode"
@@ -104,3 +110,27 @@ func HashMD5(text string) string {
 	hash := md5.Sum([]byte(text))
 	return hex.EncodeToString(hash[:])
 }
+
+// limits for uploaded pictures, shared by the messenger and the web upload
+const (
+	AvatarMaxSize      = 512 * 1024
+	AvatarMaxDimension = 1024
+)
+
+// DetectImage sniffs the image format and reads the dimensions without decoding the whole picture
+func DetectImage(data []byte) (format string, width int, height int, err error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	if config.Width <= 0 || config.Height <= 0 {
+		return "", 0, 0, errors.New("image has no dimensions")
+	}
+
+	if format == "jpeg" {
+		format = "jpg"
+	}
+
+	return format, config.Width, config.Height, nil
+}